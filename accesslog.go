@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// accessLogEntry has the fields of echo's default Logger format, so existing
+// log pipelines keep parsing the lines
+type accessLogEntry struct {
+	Time         string `json:"time"`
+	ID           string `json:"id"`
+	RemoteIP     string `json:"remote_ip"`
+	Host         string `json:"host"`
+	Method       string `json:"method"`
+	URI          string `json:"uri"`
+	UserAgent    string `json:"user_agent"`
+	Status       int    `json:"status"`
+	Error        string `json:"error"`
+	Latency      int64  `json:"latency"`
+	LatencyHuman string `json:"latency_human"`
+	BytesIn      int64  `json:"bytes_in"`
+	BytesOut     int64  `json:"bytes_out"`
+}
+
+// accessLog writes one JSON line per request to out. Failed requests (an
+// error or a status of 400 or more) are always logged; of the successful
+// ones only every sampleRate-th is, so busy instances log less.
+func accessLog(out io.Writer, sampleRate int) echo.MiddlewareFunc {
+	var successes atomic.Uint64
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		HandleError:      true,
+		LogLatency:       true,
+		LogRemoteIP:      true,
+		LogHost:          true,
+		LogMethod:        true,
+		LogURI:           true,
+		LogRequestID:     true,
+		LogUserAgent:     true,
+		LogStatus:        true,
+		LogError:         true,
+		LogContentLength: true,
+		LogResponseSize:  true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			if v.Error == nil && v.Status < 400 && successes.Add(1)%uint64(sampleRate) != 0 {
+				return nil
+			}
+			entry := accessLogEntry{
+				Time:         time.Now().Format(time.RFC3339Nano),
+				ID:           v.RequestID,
+				RemoteIP:     v.RemoteIP,
+				Host:         v.Host,
+				Method:       v.Method,
+				URI:          v.URI,
+				UserAgent:    v.UserAgent,
+				Status:       v.Status,
+				Latency:      int64(v.Latency),
+				LatencyHuman: v.Latency.String(),
+				BytesOut:     v.ResponseSize,
+			}
+			if v.Error != nil {
+				entry.Error = v.Error.Error()
+			}
+			entry.BytesIn, _ = strconv.ParseInt(v.ContentLength, 10, 64)
+			line, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			_, err = out.Write(append(line, '\n'))
+			return err
+		},
+	})
+}
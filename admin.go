@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// adminOnly guards admin routes with the ADMIN_API_KEY bearer token. When no
+// key is configured the admin API is disabled entirely.
+func adminOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if cfg.AdminAPIKey == "" {
+			return newCodedError(http.StatusForbidden, CodeAdminDisabled, "Admin API is disabled")
+		}
+		token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminAPIKey)) != 1 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid admin credentials")
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// BatchOperation is one step of a POST /batch request. create takes a
+// UserCreateRequest body, update takes a merge patch body (as PATCH
+// /users/:id) and delete takes only the id.
+type BatchOperation struct {
+	Op   string          `json:"op" example:"create" enums:"create,update,delete"`
+	ID   uint            `json:"id,omitempty" example:"1"`
+	Body json.RawMessage `json:"body,omitempty" swaggertype:"object"`
+}
+
+// BatchRequest is an ordered list of operations run in one transaction
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchResult is the outcome of a single operation
+type BatchResult struct {
+	Index  int    `json:"index" example:"0"`
+	Op     string `json:"op" example:"create"`
+	Status int    `json:"status" example:"201"`
+	User   *User  `json:"user,omitempty"`
+}
+
+// BatchResponse lists the result of every operation, in request order
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// @Summary Run a batch of operations
+// @Description Run an ordered list of create/update/delete operations in a single transaction. If any operation fails, everything is rolled back and the error names the index of the failing operation.
+// @Tags batch
+// @Accept json
+// @Produce json
+// @Param request body BatchRequest true "Operations to run"
+// @Success 200 {object} BatchResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /batch [post]
+func runBatch(c echo.Context) error {
+	req := new(BatchRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(req.Operations) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "operations is required")
+	}
+	if len(req.Operations) > cfg.MaxBatchOperations {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("At most %d operations per batch", cfg.MaxBatchOperations))
+	}
+
+	var results []BatchResult
+	var created int
+	err := writeTx(c, func(tx *gorm.DB) error {
+		results, created = make([]BatchResult, 0, len(req.Operations)), 0
+		for i, op := range req.Operations {
+			res, err := runBatchOperation(tx, op)
+			if err != nil {
+				return batchError(i, err)
+			}
+			res.Index = i
+			if res.Op == "create" {
+				created++
+			}
+			results = append(results, res)
+		}
+		return nil
+	})
+	if err != nil {
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he
+		}
+		return dbError(err)
+	}
+
+	for n := 0; n < created; n++ {
+		usersCreatedTotal.Inc()
+		usersCreatedToday.Inc()
+	}
+	return c.JSON(http.StatusOK, BatchResponse{Results: results})
+}
+
+func runBatchOperation(tx *gorm.DB, op BatchOperation) (BatchResult, error) {
+	res := BatchResult{Op: op.Op}
+	switch op.Op {
+	case "create":
+		var req UserCreateRequest
+		dec := json.NewDecoder(bytes.NewReader(op.Body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			return res, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := (structValidator{}).Validate(&req); err != nil {
+			return res, validationError(err.(ValidationErrors))
+		}
+		user := &User{Name: req.Name, Email: normalizeEmail(req.Email)}
+		if err := insertUser(tx, user); err != nil {
+			return res, err
+		}
+		res.Status, res.User = http.StatusCreated, user
+
+	case "update":
+		var patch map[string]json.RawMessage
+		if err := json.Unmarshal(op.Body, &patch); err != nil {
+			return res, newCodedError(http.StatusBadRequest, CodeMalformedBody, "body must be a JSON object")
+		}
+		updates, err := mergePatchUpdates(patch)
+		if err != nil {
+			return res, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		user := &User{}
+		if err := applyUserUpdates(tx, op.ID, user, updates); err != nil {
+			return res, err
+		}
+		res.Status, res.User = http.StatusOK, user
+
+	case "delete":
+		if err := deleteUserTx(tx, &User{}, op.ID); err != nil {
+			return res, err
+		}
+		res.Status = http.StatusOK
+
+	default:
+		return res, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown op %q", op.Op))
+	}
+	return res, nil
+}
+
+// batchError converts the error of the operation at index into an HTTP error
+// that tells the client which operation failed.
+func batchError(index int, err error) *echo.HTTPError {
+	he := asHTTPError(err)
+	return echo.NewHTTPError(he.Code, map[string]interface{}{
+		"message": he.Message,
+		"index":   index,
+	}).SetInternal(he.Internal)
+}
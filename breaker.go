@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// errCircuitOpen is returned for queries rejected while the breaker is open
+var errCircuitOpen = errors.New("database circuit breaker is open")
+
+// Breaker states, also exported as the db_circuit_breaker_state gauge
+const (
+	breakerClosed = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// circuitBreaker fails database calls fast after threshold consecutive
+// failures. Once cooldown has passed a single probe call is let through:
+// success closes the breaker again, failure re-opens it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     int
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	threshold int
+	cooldown  time.Duration
+}
+
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return errCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) currentState() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// isDBFailure reports whether err means the database itself is unhealthy:
+// the connection could not be made or broke, the query timed out, or the
+// server dropped the session (SQLSTATE classes 08 and 57P). Everything else
+// is an answer, not an outage: constraint violations, not found, and
+// application errors such as errTenantRequired or failed validation.
+func isDBFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P")
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		pgconn.Timeout(err) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Name implements gorm.Plugin.
+func (b *circuitBreaker) Name() string { return "circuit_breaker" }
+
+// Initialize implements gorm.Plugin by wrapping every database callback.
+func (b *circuitBreaker) Initialize(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		if err := b.allow(); err != nil {
+			tx.AddError(err)
+			return
+		}
+		tx.InstanceSet("breaker:allowed", true)
+	}
+	after := func(tx *gorm.DB) {
+		if _, ok := tx.InstanceGet("breaker:allowed"); ok {
+			b.record(!isDBFailure(tx.Error))
+		}
+	}
+
+	return registerAround(db, "breaker", before, after, true)
+}
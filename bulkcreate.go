@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// BulkCreateRequest is the body of POST /users/bulk
+type BulkCreateRequest struct {
+	Users []UserCreateRequest `json:"users"`
+}
+
+// BulkCreateResult is the outcome for one user of a bulk create
+type BulkCreateResult struct {
+	Index  int            `json:"index" example:"0"`
+	Status int            `json:"status" example:"201"`
+	User   *User          `json:"user,omitempty"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+}
+
+// BulkCreateResponse lists the result for every user, in request order
+type BulkCreateResponse struct {
+	Results []BulkCreateResult `json:"results"`
+}
+
+// @Summary Create users in bulk
+// @Description Create many users at once. By default the request is atomic: if any user fails, none are created and the error names the index of the failing user. With mode=partial every user is created independently and the response (207) reports which succeeded and which failed.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body BulkCreateRequest true "Users to create"
+// @Param mode query string false "atomic (default) or partial" Enums(atomic, partial)
+// @Success 201 {object} BulkCreateResponse
+// @Success 207 {object} BulkCreateResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/bulk [post]
+func bulkCreateUsers(c echo.Context) error {
+	req := new(BulkCreateRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(req.Users) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "users is required")
+	}
+	if len(req.Users) > cfg.MaxBulkCreate {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("At most %d users per request", cfg.MaxBulkCreate))
+	}
+
+	results := make([]BulkCreateResult, len(req.Users))
+	created := 0
+	switch c.QueryParam("mode") {
+	case "", "atomic":
+		err := writeTx(c, func(tx *gorm.DB) error {
+			for i := range req.Users {
+				user, err := createFromRequest(c, tx, &req.Users[i])
+				if err != nil {
+					return batchError(i, err)
+				}
+				results[i] = BulkCreateResult{Index: i, Status: http.StatusCreated, User: user}
+			}
+			return nil
+		})
+		if err != nil {
+			return asHTTPError(err)
+		}
+		created = len(results)
+
+	case "partial":
+		for i := range req.Users {
+			var user *User
+			err := writeTx(c, func(tx *gorm.DB) error {
+				var err error
+				user, err = createFromRequest(c, tx, &req.Users[i])
+				return err
+			})
+			if err != nil {
+				he := asHTTPError(err)
+				res := errorResponse(he)
+				results[i] = BulkCreateResult{Index: i, Status: he.Code, Error: &res}
+				continue
+			}
+			results[i] = BulkCreateResult{Index: i, Status: http.StatusCreated, User: user}
+			created++
+		}
+
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "mode must be atomic or partial")
+	}
+
+	for n := 0; n < created; n++ {
+		usersCreatedTotal.Inc()
+		usersCreatedToday.Inc()
+	}
+	if c.QueryParam("mode") == "partial" {
+		return c.JSON(http.StatusMultiStatus, BulkCreateResponse{Results: results})
+	}
+	return c.JSON(http.StatusCreated, BulkCreateResponse{Results: results})
+}
+
+// BulkValidateResult is the verdict for one user of POST /users/bulk/validate
+type BulkValidateResult struct {
+	Index int            `json:"index" example:"0"`
+	Valid bool           `json:"valid" example:"true"`
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+// BulkValidateResponse lists the verdict for every user, in request order.
+// Valid is true when all of them would be created.
+type BulkValidateResponse struct {
+	Valid   bool                 `json:"valid" example:"true"`
+	Results []BulkValidateResult `json:"results"`
+}
+
+// @Summary Validate users for a bulk create
+// @Description Check a POST /users/bulk body without creating anything: every user gets the validation and email uniqueness checks the bulk create would run, and emails repeated within the batch are flagged on every occurrence after the first. The verdicts hold until the data changes, so the bulk create can still fail if the same emails are registered in between.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body BulkCreateRequest true "Users to check"
+// @Success 200 {object} BulkValidateResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/bulk/validate [post]
+func validateBulkCreate(c echo.Context) error {
+	req := new(BulkCreateRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(req.Users) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "users is required")
+	}
+	if len(req.Users) > cfg.MaxBulkCreate {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("At most %d users per request", cfg.MaxBulkCreate))
+	}
+
+	results := make([]BulkValidateResult, len(req.Users))
+	fail := func(i int, he *echo.HTTPError) {
+		res := errorResponse(he)
+		results[i] = BulkValidateResult{Index: i, Error: &res}
+	}
+	seen := map[string]bool{}
+	var emails []string
+	for i := range req.Users {
+		results[i] = BulkValidateResult{Index: i, Valid: true}
+		if err := c.Validate(&req.Users[i]); err != nil {
+			if verrs, ok := err.(ValidationErrors); ok {
+				fail(i, validationError(verrs))
+			} else {
+				fail(i, echo.NewHTTPError(http.StatusBadRequest, err.Error()))
+			}
+			continue
+		}
+		email := normalizeEmail(req.Users[i].Email)
+		if seen[email] {
+			fail(i, newCodedError(http.StatusConflict, CodeEmailTaken, "Email is repeated in this batch"))
+			continue
+		}
+		seen[email] = true
+		emails = append(emails, email)
+	}
+
+	taken := map[string]bool{}
+	if len(emails) > 0 {
+		var err error
+		if taken, err = takenEmails(dbFor(c), emails); err != nil {
+			return dbError(err)
+		}
+	}
+	valid := true
+	for i := range results {
+		if results[i].Valid && taken[normalizeEmail(req.Users[i].Email)] {
+			fail(i, newCodedError(http.StatusConflict, CodeEmailTaken, "Email already in use"))
+		}
+		valid = valid && results[i].Valid
+	}
+	return c.JSON(http.StatusOK, BulkValidateResponse{Valid: valid, Results: results})
+}
+
+// createFromRequest validates req and inserts the user it describes.
+func createFromRequest(c echo.Context, tx *gorm.DB, req *UserCreateRequest) (*User, error) {
+	if err := c.Validate(req); err != nil {
+		if verrs, ok := err.(ValidationErrors); ok {
+			return nil, validationError(verrs)
+		}
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	user := &User{Name: req.Name, Email: normalizeEmail(req.Email)}
+	if err := insertUser(tx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
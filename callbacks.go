@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// registerAround registers before and after to run around every GORM
+// operation, named "<name>:before_<op>" and "<name>:after_<op>". Row
+// queries are streamed by the caller after the callback returns, so they
+// are only wrapped when includeRow is set. Dry runs only build SQL, so
+// neither hook runs for them.
+func registerAround(db *gorm.DB, name string, before, after func(*gorm.DB), includeRow bool) error {
+	before, after = skipDryRun(before), skipDryRun(after)
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().Before("gorm:create").Register(name+":before_create", before),
+		cb.Create().After("gorm:create").Register(name+":after_create", after),
+		cb.Query().Before("gorm:query").Register(name+":before_query", before),
+		cb.Query().After("gorm:query").Register(name+":after_query", after),
+		cb.Update().Before("gorm:update").Register(name+":before_update", before),
+		cb.Update().After("gorm:update").Register(name+":after_update", after),
+		cb.Delete().Before("gorm:delete").Register(name+":before_delete", before),
+		cb.Delete().After("gorm:delete").Register(name+":after_delete", after),
+		cb.Raw().Before("gorm:raw").Register(name+":before_raw", before),
+		cb.Raw().After("gorm:raw").Register(name+":after_raw", after),
+	}
+	if includeRow {
+		errs = append(errs,
+			cb.Row().Before("gorm:row").Register(name+":before_row", before),
+			cb.Row().After("gorm:row").Register(name+":after_row", after),
+		)
+	}
+	return errors.Join(errs...)
+}
+
+func skipDryRun(f func(*gorm.DB)) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if !tx.DryRun {
+			f(tx)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// injectChaos makes requests misbehave on purpose so client timeouts and
+// retries can be tested: with probability delayRate a request is held for
+// delay before being handled, and with probability errorRate it fails with
+// 500 instead. Affected responses carry X-Chaos naming what was injected.
+// Only routes in routes (templates such as /user/:id) are affected, or all
+// of them when it is empty.
+func injectChaos(delay time.Duration, delayRate, errorRate float64, routes []string) echo.MiddlewareFunc {
+	only := map[string]bool{}
+	for _, r := range routes {
+		only[r] = true
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if len(only) > 0 && !only[c.Path()] {
+				return next(c)
+			}
+			if rand.Float64() < delayRate {
+				c.Response().Header().Add("X-Chaos", "delay")
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-c.Request().Context().Done():
+					timer.Stop()
+					return c.Request().Context().Err()
+				}
+			}
+			if rand.Float64() < errorRate {
+				c.Response().Header().Add("X-Chaos", "error")
+				return echo.NewHTTPError(http.StatusInternalServerError, "Injected failure")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"crypto/rand"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime settings read from the environment. Settings
+// marked required abort startup when missing; recommended ones log a warning
+// and fall back to a default; everything else silently uses its default.
+// Fields tagged config:"secret" are redacted by GET /admin/config.
+type Config struct {
+	Env string
+
+	DBHost     string // required
+	DBUser     string // required
+	DBName     string // required
+	DBPort     string // recommended
+	DBPassword string `config:"secret"` // recommended
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	ShutdownTimeout time.Duration
+	TLSCertFile     string
+	TLSKeyFile      string
+	AutoTLSDomains  []string
+	AutoTLSCacheDir string
+
+	DefaultPageSize      int
+	MaxPageSize          int
+	RejectOversizedPages bool
+	MaxScanRows          int
+
+	DBSlowQueryThreshold time.Duration
+
+	EmailCheckRatePerMinute int
+
+	UserCountRefreshInterval time.Duration
+
+	TrustedProxies []*net.IPNet
+
+	SwaggerHost    string
+	SwaggerSchemes []string
+
+	WebhookURL          string `config:"secret"`
+	WebhookPollInterval time.Duration
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookSecret       []byte `config:"secret"`
+	WebhookEvents       map[string]bool
+
+	JSONPretty bool
+	JSONNaming string
+
+	AutoMigrate         bool
+	AdminAPIKey         string `config:"secret"`
+	AdminMigrateEnabled bool
+
+	MaxBatchIDs        int
+	MaxBatchOperations int
+
+	DefaultSort    string
+	DefaultSortDir string
+
+	AllowedHosts []string
+
+	DBBreakerThreshold int
+	DBBreakerCooldown  time.Duration
+
+	SwaggerCacheMaxAge time.Duration
+
+	CursorSigningKey []byte `config:"secret"` // recommended
+
+	ExpensiveRatePerSecond float64
+	ExpensiveRateBurst     int
+
+	UndoDeleteWindow time.Duration
+
+	DBQueryTimeout time.Duration
+
+	Features map[string]bool
+
+	ServerTiming bool
+
+	ExportDir          string
+	ExportPollInterval time.Duration
+	ExportTTL          time.Duration
+
+	SoftDeleteEnabled bool
+
+	SchemaDriftStrict bool
+
+	RequireIfMatch bool
+
+	DBMaxIdleConns int
+	DBMaxOpenConns int
+	DBWarmup       bool
+	DBWarmupConns  int
+
+	RequestLogSize int
+
+	MaxBulkCreate int
+
+	MaxDecompressedBody int64
+
+	EnablePprof bool
+
+	MaxConcurrentRequests int
+
+	ClientIDHeader   string
+	AllowedClientIDs []string
+
+	MultiTenant  bool
+	TenantHeader string
+
+	LogSampleRate int
+
+	DedupWindow time.Duration
+
+	ProblemTypeBase string
+
+	ChaosEnabled   bool
+	ChaosDelay     time.Duration
+	ChaosDelayRate float64
+	ChaosErrorRate float64
+	ChaosRoutes    []string
+}
+
+var cfg Config
+
+func loadConfig() {
+	var missing []string
+	cfg = Config{
+		Env: getEnv("ENV", "development"),
+
+		DBHost:     requiredEnv("DB_HOST", &missing),
+		DBUser:     requiredEnv("DB_USER", &missing),
+		DBName:     requiredEnv("DB_NAME", &missing),
+		DBPort:     recommendedEnv("DB_PORT", "5432"),
+		DBPassword: recommendedEnv("DB_PASSWORD", ""),
+
+		ReadTimeout:  getEnvDuration("SERVER_READ_TIMEOUT", 10*time.Second),
+		WriteTimeout: getEnvDuration("SERVER_WRITE_TIMEOUT", 15*time.Second),
+		IdleTimeout:  getEnvDuration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+
+		ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		TLSCertFile:     os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:      os.Getenv("TLS_KEY_FILE"),
+		AutoTLSDomains:  getEnvList("AUTOTLS_DOMAINS"),
+		AutoTLSCacheDir: getEnv("AUTOTLS_CACHE_DIR", ".cache/autocert"),
+
+		DefaultPageSize:      getEnvInt("DEFAULT_PAGE_SIZE", 20),
+		MaxPageSize:          getEnvInt("MAX_PAGE_SIZE", 100),
+		RejectOversizedPages: getEnvBool("REJECT_OVERSIZED_PAGES", false),
+		MaxScanRows:          getEnvInt("MAX_SCAN_ROWS", 0),
+
+		DBSlowQueryThreshold: time.Duration(getEnvInt("DB_SLOW_QUERY_MS", 200)) * time.Millisecond,
+
+		EmailCheckRatePerMinute: getEnvInt("EMAIL_CHECK_RATE_PER_MINUTE", 10),
+
+		UserCountRefreshInterval: getEnvDuration("USER_COUNT_REFRESH_INTERVAL", time.Minute),
+
+		SwaggerHost:    os.Getenv("SWAGGER_HOST"),
+		SwaggerSchemes: getEnvList("SWAGGER_SCHEME"),
+
+		WebhookURL:          envOrFile("WEBHOOK_URL"),
+		WebhookPollInterval: getEnvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		WebhookTimeout:      getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookMaxAttempts:  getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10),
+		WebhookSecret:       []byte(envOrFile("WEBHOOK_SECRET")),
+		WebhookEvents:       map[string]bool{},
+
+		JSONPretty: getEnvBool("JSON_PRETTY", false),
+		JSONNaming: getEnv("JSON_NAMING", "snake"),
+
+		AutoMigrate:         getEnvBool("DB_AUTO_MIGRATE", true),
+		AdminAPIKey:         envOrFile("ADMIN_API_KEY"),
+		AdminMigrateEnabled: getEnvBool("ADMIN_MIGRATE_ENABLED", false),
+
+		MaxBatchIDs:        getEnvInt("MAX_BATCH_IDS", 100),
+		MaxBatchOperations: getEnvInt("MAX_BATCH_OPERATIONS", 50),
+
+		DefaultSort:    getEnv("DEFAULT_SORT", "id"),
+		DefaultSortDir: strings.ToLower(getEnv("DEFAULT_SORT_DIR", "asc")),
+
+		AllowedHosts: getEnvList("ALLOWED_HOSTS"),
+
+		DBBreakerThreshold: getEnvInt("DB_BREAKER_THRESHOLD", 5),
+		DBBreakerCooldown:  getEnvDuration("DB_BREAKER_COOLDOWN", 30*time.Second),
+
+		SwaggerCacheMaxAge: getEnvDuration("SWAGGER_CACHE_MAX_AGE", 24*time.Hour),
+
+		CursorSigningKey: []byte(envOrFile("CURSOR_SIGNING_KEY")),
+
+		ExpensiveRatePerSecond: getEnvFloat("EXPENSIVE_RATE_PER_SECOND", 1),
+		ExpensiveRateBurst:     getEnvInt("EXPENSIVE_RATE_BURST", 1),
+
+		UndoDeleteWindow: getEnvDuration("UNDO_DELETE_WINDOW", 5*time.Minute),
+
+		DBQueryTimeout: getEnvDuration("DB_QUERY_TIMEOUT", 10*time.Second),
+
+		Features: map[string]bool{},
+
+		ServerTiming: getEnvBool("SERVER_TIMING", true),
+
+		ExportDir:          getEnv("EXPORT_DIR", filepath.Join(os.TempDir(), "user-exports")),
+		ExportPollInterval: getEnvDuration("EXPORT_POLL_INTERVAL", 2*time.Second),
+		ExportTTL:          getEnvDuration("EXPORT_TTL", 24*time.Hour),
+
+		SoftDeleteEnabled: getEnvBool("SOFT_DELETE_ENABLED", true),
+
+		SchemaDriftStrict: getEnvBool("SCHEMA_DRIFT_STRICT", false),
+
+		RequireIfMatch: getEnvBool("REQUIRE_IF_MATCH", false),
+
+		DBMaxIdleConns: getEnvInt("DB_MAX_IDLE_CONNS", 2),
+		DBMaxOpenConns: getEnvInt("DB_MAX_OPEN_CONNS", 0),
+		DBWarmup:       getEnvBool("DB_WARMUP", false),
+
+		RequestLogSize: getEnvInt("REQUEST_LOG_SIZE", 500),
+
+		MaxBulkCreate: getEnvInt("MAX_BULK_CREATE", 500),
+
+		MaxDecompressedBody: int64(getEnvInt("MAX_DECOMPRESSED_BODY", 10<<20)),
+
+		EnablePprof: getEnvBool("ENABLE_PPROF", false),
+
+		MaxConcurrentRequests: getEnvInt("MAX_CONCURRENT_REQUESTS", 0),
+
+		ClientIDHeader:   getEnv("CLIENT_ID_HEADER", "X-Client-ID"),
+		AllowedClientIDs: getEnvList("ALLOWED_CLIENT_IDS"),
+
+		MultiTenant:  getEnvBool("MULTI_TENANT", false),
+		TenantHeader: getEnv("TENANT_HEADER", "X-Tenant-ID"),
+
+		LogSampleRate: getEnvInt("LOG_SAMPLE_RATE", 1),
+
+		DedupWindow: getEnvDuration("DEDUP_WINDOW", 0),
+
+		ProblemTypeBase: getEnv("PROBLEM_TYPE_BASE", "/problems/"),
+
+		ChaosEnabled:   getEnvBool("CHAOS_ENABLED", false),
+		ChaosDelay:     getEnvDuration("CHAOS_DELAY", 2*time.Second),
+		ChaosDelayRate: getEnvFloat("CHAOS_DELAY_RATE", 0),
+		ChaosErrorRate: getEnvFloat("CHAOS_ERROR_RATE", 0),
+		ChaosRoutes:    getEnvList("CHAOS_ROUTES"),
+	}
+	cfg.DBWarmupConns = min(getEnvInt("DB_WARMUP_CONNS", cfg.DBMaxIdleConns), cfg.DBMaxIdleConns)
+	if len(missing) > 0 {
+		log.Fatalf("Missing required settings: %s", strings.Join(missing, ", "))
+	}
+	for _, name := range getEnvList("FEATURES") {
+		cfg.Features[name] = true
+	}
+	webhookEvents := getEnvList("WEBHOOK_EVENTS")
+	if len(webhookEvents) == 0 {
+		webhookEvents = webhookEventTypes
+	}
+	for _, name := range webhookEvents {
+		if !slices.Contains(webhookEventTypes, name) {
+			log.Fatalf("Unknown event %q in WEBHOOK_EVENTS", name)
+		}
+		cfg.WebhookEvents[name] = true
+	}
+
+	if len(cfg.CursorSigningKey) == 0 {
+		log.Printf("Warning: CURSOR_SIGNING_KEY not set; using a random key, cursors will not survive restarts")
+		cfg.CursorSigningKey = make([]byte, 32)
+		if _, err := rand.Read(cfg.CursorSigningKey); err != nil {
+			log.Fatalf("Failed to generate cursor signing key: %v", err)
+		}
+	}
+
+	for _, cidr := range getEnvList("TRUSTED_PROXIES") {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("Invalid CIDR in TRUSTED_PROXIES: %v", err)
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, ipNet)
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSCertFile != "" && len(cfg.AutoTLSDomains) > 0 {
+		log.Fatalf("TLS_CERT_FILE and AUTOTLS_DOMAINS cannot be used together")
+	}
+	if _, ok := filterableColumns[cfg.DefaultSort]; !ok {
+		log.Fatalf("DEFAULT_SORT %q is not a sortable column", cfg.DefaultSort)
+	}
+	if cfg.DefaultSortDir != "asc" && cfg.DefaultSortDir != "desc" {
+		log.Fatalf("DEFAULT_SORT_DIR must be asc or desc")
+	}
+	if cfg.LogSampleRate < 1 {
+		log.Fatalf("LOG_SAMPLE_RATE must be at least 1")
+	}
+	if cfg.JSONNaming != "snake" && cfg.JSONNaming != "camel" {
+		log.Fatalf("JSON_NAMING must be snake or camel")
+	}
+	if cfg.ChaosEnabled {
+		if env := strings.ToLower(cfg.Env); env == "production" || env == "prod" {
+			log.Fatalf("CHAOS_ENABLED cannot be used with ENV=%s", cfg.Env)
+		}
+		if cfg.ChaosDelayRate < 0 || cfg.ChaosDelayRate > 1 || cfg.ChaosErrorRate < 0 || cfg.ChaosErrorRate > 1 {
+			log.Fatalf("CHAOS_DELAY_RATE and CHAOS_ERROR_RATE must be between 0 and 1")
+		}
+		log.Printf("Warning: chaos testing enabled; delaying %g and failing %g of requests", cfg.ChaosDelayRate, cfg.ChaosErrorRate)
+	}
+	if cfg.MaxScanRows < 0 {
+		log.Fatalf("MAX_SCAN_ROWS must not be negative")
+	}
+	if cfg.DefaultPageSize < 1 || cfg.MaxPageSize < cfg.DefaultPageSize {
+		log.Fatalf("DEFAULT_PAGE_SIZE must be positive and not exceed MAX_PAGE_SIZE")
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// envOrFile returns the value of key, or when key_FILE is set the contents
+// of the file it names (as with Docker and Kubernetes secrets), without a
+// trailing newline. An unreadable file aborts startup.
+func envOrFile(key string) string {
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return os.Getenv(key)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read %s_FILE: %v", key, err)
+	}
+	return strings.TrimRight(string(b), "\r\n")
+}
+
+// requiredEnv returns the value of key, adding key to missing when unset so
+// every missing setting can be reported at once.
+func requiredEnv(key string, missing *[]string) string {
+	v := envOrFile(key)
+	if v == "" {
+		*missing = append(*missing, key)
+	}
+	return v
+}
+
+// recommendedEnv returns the value of key, or fallback with a warning when
+// unset.
+func recommendedEnv(key, fallback string) string {
+	v := envOrFile(key)
+	if v == "" {
+		log.Printf("Warning: %s not set; using %q", key, fallback)
+		return fallback
+	}
+	return v
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func getEnvInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Invalid integer for %s: %v", key, err)
+	}
+	return n
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("Invalid number for %s: %v", key, err)
+	}
+	return f
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("Invalid boolean for %s: %v", key, err)
+	}
+	return b
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Invalid duration for %s: %v", key, err)
+	}
+	return d
+}
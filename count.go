@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// CountResponse is returned by GET /users/count
+type CountResponse struct {
+	Count      int64      `json:"count" example:"1024"`
+	ComputedAt *time.Time `json:"computed_at,omitempty"`
+	// Approximate is set when Count is the planner's estimate
+	Approximate bool `json:"approximate,omitempty"`
+}
+
+// countCache holds the user count computed by the background refresher.
+type countCache struct {
+	mu         sync.RWMutex
+	count      int64
+	computedAt time.Time
+}
+
+var userCountCache countCache
+
+func (cc *countCache) get() (int64, time.Time) {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.count, cc.computedAt
+}
+
+func (cc *countCache) set(count int64) time.Time {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.count = count
+	cc.computedAt = time.Now().UTC()
+	return cc.computedAt
+}
+
+func countUsers(q *gorm.DB) (int64, error) {
+	var count int64
+	err := q.Model(&User{}).Count(&count).Error
+	return count, err
+}
+
+// estimateUsers returns Postgres's row estimate for the users table from
+// pg_class.reltuples, as maintained by VACUUM and ANALYZE. It includes
+// soft-deleted rows. ok is false when no estimate is available: on other
+// databases, or before the table was first analyzed (reltuples is -1).
+func estimateUsers(q *gorm.DB) (count int64, ok bool, err error) {
+	if q.Dialector.Name() != "postgres" {
+		return 0, false, nil
+	}
+	var estimate float64
+	err = q.Raw("SELECT reltuples FROM pg_class WHERE oid = to_regclass(?)", "users").Scan(&estimate).Error
+	if err != nil || estimate < 0 {
+		return 0, false, err
+	}
+	return int64(estimate), true, nil
+}
+
+// estimateRows returns the planner's estimate of how many users q selects,
+// from EXPLAIN. ok is false outside Postgres.
+func estimateRows(q *gorm.DB) (rows int64, ok bool, err error) {
+	if q.Dialector.Name() != "postgres" {
+		return 0, false, nil
+	}
+	stmt := q.Session(&gorm.Session{DryRun: true}).Find(&[]User{})
+	if stmt.Error != nil {
+		return 0, false, stmt.Error
+	}
+	var raw string
+	err = q.Session(&gorm.Session{NewDB: true}).
+		Raw("EXPLAIN (FORMAT JSON) "+stmt.Statement.SQL.String(), stmt.Statement.Vars...).
+		Scan(&raw).Error
+	if err != nil {
+		return 0, false, err
+	}
+	var plan []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		}
+	}
+	// Servers that merely speak the Postgres protocol may not honour FORMAT JSON
+	if json.Unmarshal([]byte(raw), &plan) != nil || len(plan) == 0 {
+		return 0, false, nil
+	}
+	return int64(plan[0].Plan.Rows), true, nil
+}
+
+// refreshUserCount recomputes the cached user count every interval until
+// ctx is cancelled.
+func refreshUserCount(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if count, err := countUsers(db.WithContext(ctx)); err != nil {
+			log.Printf("Failed to refresh user count: %v", err)
+		} else {
+			userCountCache.set(count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// @Summary Count users
+// @Description Count users, optionally narrowed with the filter params of GET /users. With cached=true the value computed by the background job is returned along with when it was computed. With approximate=true the Postgres planner estimate is returned instead of running COUNT(*), flagged with approximate; it is much faster on large tables but includes deleted users and may lag behind. An exact count is returned when no estimate exists yet. Filtered counts and counts in multi-tenant mode are always exact. Exact counts are rejected with 400 when Postgres estimates more than MAX_SCAN_ROWS users match.
+// @Tags users
+// @Produce json
+// @Param cached query bool false "Return the periodically cached count"
+// @Param approximate query bool false "Return the planner's row estimate"
+// @Success 200 {object} CountResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/count [get]
+func getUserCount(c echo.Context) error {
+	q, err := applyScopes(c, dbFor(c))
+	if err != nil {
+		return err
+	}
+	// The cache and the estimate count all users of every tenant, so they
+	// can only answer unfiltered counts outside multi-tenant mode
+	shared := !cfg.MultiTenant && !hasFilters(c)
+
+	if shared && c.QueryParam("approximate") == "true" {
+		count, ok, err := estimateUsers(dbFor(c))
+		if err != nil {
+			return dbError(err)
+		}
+		if ok {
+			return c.JSON(http.StatusOK, CountResponse{Count: count, Approximate: true})
+		}
+	}
+	cached := shared && c.QueryParam("cached") == "true"
+	if cached {
+		if count, at := userCountCache.get(); !at.IsZero() {
+			return c.JSON(http.StatusOK, CountResponse{Count: count, ComputedAt: &at})
+		}
+	}
+
+	if err := guardScan(q); err != nil {
+		return err
+	}
+	if cached {
+		// Not computed yet; count live and seed the cache
+		count, err := countUsers(q)
+		if err != nil {
+			return dbError(err)
+		}
+		at := userCountCache.set(count)
+		return c.JSON(http.StatusOK, CountResponse{Count: count, ComputedAt: &at})
+	}
+
+	count, err := countUsers(q)
+	if err != nil {
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, CountResponse{Count: count})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// cursor is the position encoded in a next_cursor token. Filter is a
+// fingerprint of the filters and sort the cursor was issued for.
+type cursor struct {
+	LastID uint   `json:"id"`
+	Filter string `json:"f"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor returns cur as "<payload>.<signature>", both base64url, where
+// the signature is an HMAC-SHA256 of the payload with CURSOR_SIGNING_KEY.
+func encodeCursor(cur cursor) string {
+	payload, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signCursor(payload))
+}
+
+// decodeCursor verifies the signature of token and returns its cursor.
+func decodeCursor(token string) (cursor, error) {
+	var cur cursor
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return cur, errInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return cur, errInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, signCursor(payload)) {
+		return cur, errInvalidCursor
+	}
+	if err := json.Unmarshal(payload, &cur); err != nil {
+		return cur, errInvalidCursor
+	}
+	return cur, nil
+}
+
+func signCursor(payload []byte) []byte {
+	mac := hmac.New(sha256.New, cfg.CursorSigningKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// filterFingerprint hashes the filter params and sort direction so a cursor
+// cannot be replayed against a different query.
+func filterFingerprint(c echo.Context, s Sort) string {
+	var parts []string
+	for key, values := range c.QueryParams() {
+		if strings.HasPrefix(key, "filter[") {
+			for _, v := range values {
+				parts = append(parts, key+"="+v)
+			}
+		}
+	}
+	sort.Strings(parts)
+	parts = append(parts, "sort="+s.Column+" "+s.Dir)
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "&")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// applyCursor restricts q to rows after the cursor param, if one was given.
+// Cursors only work with id ordering and cannot be combined with page.
+func applyCursor(c echo.Context, q *gorm.DB, s Sort) (*gorm.DB, error) {
+	token := c.QueryParam("cursor")
+	if token == "" {
+		return q, nil
+	}
+	if s.Column != "id" {
+		return nil, newCodedError(http.StatusBadRequest, CodeInvalidCursor, "cursor requires sorting by id")
+	}
+	if c.QueryParam("page") != "" {
+		return nil, newCodedError(http.StatusBadRequest, CodeInvalidCursor, "cursor cannot be combined with page")
+	}
+
+	cur, err := decodeCursor(token)
+	if err != nil {
+		return nil, newCodedError(http.StatusBadRequest, CodeInvalidCursor, "Invalid cursor")
+	}
+	if cur.Filter != filterFingerprint(c, s) {
+		return nil, newCodedError(http.StatusBadRequest, CodeInvalidCursor, "Cursor does not match the current filters")
+	}
+
+	if s.Dir == "desc" {
+		return q.Where("id < ?", cur.LastID), nil
+	}
+	return q.Where("id > ?", cur.LastID), nil
+}
+
+// setNextCursor adds X-Next-Cursor and a Link rel="next" header when a full
+// page ordered by id was returned, so clients can continue with keyset
+// pagination.
+func setNextCursor(c echo.Context, s Sort, users []User, pageSize int) {
+	if s.Column != "id" || len(users) < pageSize || len(users) == 0 {
+		return
+	}
+	token := encodeCursor(cursor{LastID: users[len(users)-1].ID, Filter: filterFingerprint(c, s)})
+
+	next := *c.Request().URL
+	query := next.Query()
+	query.Del("page")
+	query.Set("cursor", token)
+	next.RawQuery = query.Encode()
+
+	h := c.Response().Header()
+	h.Set("X-Next-Cursor", token)
+	h.Add("Link", `<`+next.RequestURI()+`>; rel="next"`)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// walkUsers follows X-Next-Cursor from target until it runs out, calling
+// between(page) after each page, and returns how often each user was seen.
+func walkUsers(t *testing.T, e *echo.Echo, target string, between func(page int)) map[uint]int {
+	t.Helper()
+	seen := map[uint]int{}
+	for page := 1; ; page++ {
+		rec := serve(e, target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("page %d: status %d: %s", page, rec.Code, rec.Body)
+		}
+		var users []User
+		if err := json.Unmarshal(rec.Body.Bytes(), &users); err != nil {
+			t.Fatalf("page %d: %v", page, err)
+		}
+		for _, u := range users {
+			seen[u.ID]++
+		}
+		next := rec.Header().Get("X-Next-Cursor")
+		if next == "" {
+			return seen
+		}
+		if page > 100 {
+			t.Fatal("cursors never ran out")
+		}
+		between(page)
+		u, _ := url.Parse(target)
+		q := u.Query()
+		q.Set("cursor", next)
+		u.RawQuery = q.Encode()
+		target = u.String()
+	}
+}
+
+func createTestUsers(t *testing.T, n int, name func(i int) string) []User {
+	t.Helper()
+	users := make([]User, n)
+	for i := range users {
+		users[i] = User{Name: name(i), Email: fmt.Sprintf("user%d-%s@example.com", i, name(i))}
+	}
+	if err := db.Create(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func TestCursorPaginationSeesEveryUserOnce(t *testing.T) {
+	const total, pageSize = 57, 10
+	tests := []struct {
+		name  string
+		query string
+		// mutate changes the table after the first page and returns the IDs
+		// of users that must be seen once and of users that must not be seen
+		mutate func(t *testing.T, users []User) (added, removed []uint)
+	}{
+		{name: "unchanged", query: "sort_dir=asc"},
+		{name: "descending", query: "sort_dir=desc"},
+		{
+			name:  "users inserted mid-walk",
+			query: "sort_dir=asc",
+			mutate: func(t *testing.T, users []User) (added, removed []uint) {
+				for _, u := range createTestUsers(t, 5, func(i int) string { return fmt.Sprintf("late-%d", i) }) {
+					added = append(added, u.ID)
+				}
+				return added, nil
+			},
+		},
+		{
+			name:  "users deleted mid-walk",
+			query: "sort_dir=asc",
+			mutate: func(t *testing.T, users []User) (added, removed []uint) {
+				// A user from the page already returned still counts as seen
+				if err := db.Delete(&User{}, users[3].ID).Error; err != nil {
+					t.Fatal(err)
+				}
+				for _, u := range []User{users[20], users[21], users[total-1]} {
+					if err := db.Delete(&User{}, u.ID).Error; err != nil {
+						t.Fatal(err)
+					}
+					removed = append(removed, u.ID)
+				}
+				return nil, removed
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireDB(t)
+			users := createTestUsers(t, total, func(i int) string { return fmt.Sprintf("user-%d", i) })
+			e := echo.New()
+			e.GET("/users", getUsers)
+
+			var added, removed []uint
+			seen := walkUsers(t, e, fmt.Sprintf("/users?page_size=%d&%s", pageSize, tt.query), func(page int) {
+				if page == 1 && tt.mutate != nil {
+					added, removed = tt.mutate(t, users)
+				}
+			})
+
+			gone := map[uint]bool{}
+			for _, id := range removed {
+				gone[id] = true
+			}
+			want := added
+			for _, u := range users {
+				if !gone[u.ID] {
+					want = append(want, u.ID)
+				}
+			}
+			for _, id := range want {
+				if seen[id] != 1 {
+					t.Errorf("user %d seen %d times, want once", id, seen[id])
+				}
+			}
+			for id := range seen {
+				if gone[id] {
+					t.Errorf("user %d was deleted before its page but was returned", id)
+				}
+			}
+		})
+	}
+}
+
+func TestPagesWithTiedSortKeysSeeEveryUserOnce(t *testing.T) {
+	requireDB(t)
+	// Only three distinct names, so the order within a name relies on the
+	// id tiebreaker
+	users := createTestUsers(t, 45, func(i int) string { return []string{"Ann", "Bob", "Cy"}[i%3] })
+	e := echo.New()
+	e.GET("/users", getUsers)
+
+	for _, dir := range []string{"asc", "desc"} {
+		seen := map[uint]int{}
+		for page := 1; ; page++ {
+			rec := serve(e, fmt.Sprintf("/users?sort=name&sort_dir=%s&page_size=7&page=%d", dir, page))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s page %d: status %d: %s", dir, page, rec.Code, rec.Body)
+			}
+			var got []User
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) == 0 {
+				break
+			}
+			for _, u := range got {
+				seen[u.ID]++
+			}
+		}
+		for _, u := range users {
+			if seen[u.ID] != 1 {
+				t.Errorf("sort_dir=%s: user %d seen %d times, want once", dir, u.ID, seen[u.ID])
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DBStatsResponse is the state of the database connection pool, as reported
+// by database/sql
+type DBStatsResponse struct {
+	MaxOpenConnections int   `json:"max_open_connections" example:"10"`
+	OpenConnections    int   `json:"open_connections" example:"4"`
+	InUse              int   `json:"in_use" example:"3"`
+	Idle               int   `json:"idle" example:"1"`
+	WaitCount          int64 `json:"wait_count" example:"0"`
+	WaitDurationMs     int64 `json:"wait_duration_ms" example:"0"`
+	MaxIdleClosed      int64 `json:"max_idle_closed" example:"0"`
+	MaxIdleTimeClosed  int64 `json:"max_idle_time_closed" example:"0"`
+	MaxLifetimeClosed  int64 `json:"max_lifetime_closed" example:"0"`
+}
+
+// poolStats returns the connection pool statistics, or zeroes before the
+// database is opened.
+func poolStats() sql.DBStats {
+	if db == nil {
+		return sql.DBStats{}
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return sql.DBStats{}
+	}
+	return sqlDB.Stats()
+}
+
+var (
+	_ = NewGaugeFunc("db_pool_max_open_connections", "Maximum number of open database connections (DB_MAX_OPEN_CONNS).",
+		func() float64 { return float64(poolStats().MaxOpenConnections) })
+	_ = NewGaugeFunc("db_pool_open_connections", "Open database connections, in use and idle.",
+		func() float64 { return float64(poolStats().OpenConnections) })
+	_ = NewGaugeFunc("db_pool_in_use_connections", "Database connections currently in use.",
+		func() float64 { return float64(poolStats().InUse) })
+	_ = NewGaugeFunc("db_pool_idle_connections", "Idle database connections.",
+		func() float64 { return float64(poolStats().Idle) })
+	_ = NewCounterFunc("db_pool_wait_count_total", "Times a query waited for a free database connection.",
+		func() float64 { return float64(poolStats().WaitCount) })
+	_ = NewCounterFunc("db_pool_wait_seconds_total", "Total time spent waiting for a free database connection.",
+		func() float64 { return poolStats().WaitDuration.Seconds() })
+)
+
+// @Summary Get database pool statistics
+// @Description Report the database connection pool: open, in-use and idle connections against the DB_MAX_OPEN_CONNS ceiling, how often and how long queries waited for a connection, and how many connections were closed by the idle and lifetime limits. The same figures are exported at /metrics as db_pool_* series. Requires the admin API key.
+// @Tags admin
+// @Produce json
+// @Security AdminKey
+// @Success 200 {object} DBStatsResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/db-stats [get]
+func getDBStats(c echo.Context) error {
+	s := poolStats()
+	return c.JSON(http.StatusOK, DBStatsResponse{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDurationMs:     s.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	})
+}
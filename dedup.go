@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxReplayBody is the largest response dedupWrites keeps for replaying.
+// Duplicates of requests with larger responses are rejected with 409.
+const maxReplayBody = 64 << 10
+
+// dedupEntry is the outcome of a POST as seen by later identical POSTs
+type dedupEntry struct {
+	expires time.Time // zero while the original is in flight
+	status  int
+	header  http.Header
+	body    []byte // nil when the response was too large to keep
+}
+
+// replayWriter copies what a handler writes, up to maxReplayBody bytes.
+type replayWriter struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *replayWriter) Write(b []byte) (int, error) {
+	if !w.overflow {
+		if w.body.Len()+len(b) > maxReplayBody {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *replayWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// dedupWrites catches double submits: a POST identical to one that
+// succeeded less than window ago (same client IP, path, body and values of
+// keyHeaders) gets the original response replayed, marked with
+// Idempotent-Replayed: true, instead of running again. A duplicate arriving
+// while the original is still running gets 409. Failed requests are not
+// remembered, so they can be retried at once. Admin routes are exempt. The
+// window is per instance.
+func dedupWrites(window time.Duration, keyHeaders ...string) echo.MiddlewareFunc {
+	var (
+		mu        sync.Mutex
+		entries   = map[string]*dedupEntry{}
+		lastSweep time.Time
+	)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.Method != http.MethodPost || strings.HasPrefix(c.Path(), "/admin/") {
+				return next(c)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			h := sha256.New()
+			io.WriteString(h, c.RealIP()+"\n"+req.URL.RequestURI()+"\n")
+			for _, name := range keyHeaders {
+				io.WriteString(h, req.Header.Get(name)+"\n")
+			}
+			h.Write(body)
+			key := hex.EncodeToString(h.Sum(nil))
+
+			now := time.Now()
+			mu.Lock()
+			if now.Sub(lastSweep) > window {
+				for k, e := range entries {
+					if !e.expires.IsZero() && now.After(e.expires) {
+						delete(entries, k)
+					}
+				}
+				lastSweep = now
+			}
+			if found, ok := entries[key]; ok && (found.expires.IsZero() || now.Before(found.expires)) {
+				e := *found
+				mu.Unlock()
+				if e.expires.IsZero() {
+					return newCodedError(http.StatusConflict, CodeDuplicateRequest, "Duplicate request is still being processed")
+				}
+				if e.body == nil {
+					return newCodedError(http.StatusConflict, CodeDuplicateRequest, "Duplicate request")
+				}
+				header := c.Response().Header()
+				for k, v := range e.header {
+					header[k] = v
+				}
+				header.Set("Idempotent-Replayed", "true")
+				c.Response().WriteHeader(e.status)
+				_, err := c.Response().Write(e.body)
+				return err
+			}
+			entry := &dedupEntry{}
+			entries[key] = entry
+			mu.Unlock()
+
+			res := c.Response()
+			w := &replayWriter{ResponseWriter: res.Writer}
+			res.Writer = w
+			recorded := false
+			defer func() {
+				res.Writer = w.ResponseWriter
+				if !recorded {
+					mu.Lock()
+					delete(entries, key)
+					mu.Unlock()
+				}
+			}()
+
+			err = next(c)
+			if err != nil || res.Status < 200 || res.Status > 299 {
+				return err
+			}
+			mu.Lock()
+			entry.status = res.Status
+			entry.header = res.Header().Clone()
+			if !w.overflow {
+				entry.body = append([]byte{}, w.body.Bytes()...)
+			}
+			entry.expires = time.Now().Add(window)
+			mu.Unlock()
+			recorded = true
+			return nil
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultNameSimilarity is the pg_trgm similarity two names need to be
+// reported as duplicates when no similarity param is given.
+const defaultNameSimilarity = 0.6
+
+// DuplicateCluster is a group of users that likely belong to one person
+type DuplicateCluster struct {
+	Key   string `json:"key"`
+	Users []User `json:"users"`
+}
+
+// @Summary Find duplicate users
+// @Description Group users that are likely duplicates. by=email (default) matches emails ignoring case and surrounding whitespace; by=name compares names with pg_trgm similarity and requires that extension. Clusters are paginated. by=email accepts the filter params of GET /users.
+// @Tags users
+// @Produce json
+// @Param by query string false "How to match users" Enums(email, name)
+// @Param similarity query number false "Minimum name similarity for by=name, between 0 and 1 (default 0.6)"
+// @Param page query int false "Page number (1-based)"
+// @Param page_size query int false "Clusters per page"
+// @Success 200 {array} DuplicateCluster
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 501 {object} ErrorResponse
+// @Router /users/duplicates [get]
+func getDuplicateUsers(c echo.Context) error {
+	p, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	switch c.QueryParam("by") {
+	case "", "email":
+		return duplicateEmails(c, p)
+	case "name":
+		threshold := defaultNameSimilarity
+		if v := c.QueryParam("similarity"); v != "" {
+			threshold, err = strconv.ParseFloat(v, 64)
+			if err != nil || threshold <= 0 || threshold > 1 {
+				return echo.NewHTTPError(http.StatusBadRequest, "similarity must be a number between 0 and 1")
+			}
+		}
+		return duplicateNames(c, p, threshold)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "by must be one of: email, name")
+	}
+}
+
+// duplicateEmails returns clusters of users sharing a normalized email.
+func duplicateEmails(c echo.Context, p Pagination) error {
+	const key = "LOWER(TRIM(email))"
+
+	q, err := applyScopes(c, dbFor(c))
+	if err != nil {
+		return err
+	}
+	var keys []string
+	err = q.Group(key).
+		Having("COUNT(*) > 1").
+		Order(key).
+		Limit(p.PageSize).Offset(p.Offset()).
+		Pluck(key, &keys).Error
+	if err != nil {
+		return dbError(err)
+	}
+
+	clusters := []DuplicateCluster{}
+	if len(keys) == 0 {
+		return c.JSON(http.StatusOK, clusters)
+	}
+
+	var users []User
+	q, err = applyScopes(c, dbFor(c))
+	if err != nil {
+		return err
+	}
+	if err := q.Where(key+" IN ?", keys).Order("id").Find(&users).Error; err != nil {
+		return dbError(err)
+	}
+	byKey := make(map[string][]User, len(keys))
+	for _, u := range users {
+		k := strings.ToLower(strings.TrimSpace(u.Email))
+		byKey[k] = append(byKey[k], u)
+	}
+	for _, k := range keys {
+		clusters = append(clusters, DuplicateCluster{Key: k, Users: byKey[k]})
+	}
+	return c.JSON(http.StatusOK, clusters)
+}
+
+// duplicateNames returns clusters of users whose names are at least
+// threshold similar. Similar pairs are joined transitively, so a cluster may
+// contain names that are only similar through another member.
+func duplicateNames(c echo.Context, p Pagination, threshold float64) error {
+	var hasTrgm bool
+	err := dbFor(c).Raw("SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm')").
+		Scan(&hasTrgm).Error
+	if err != nil {
+		return dbError(err)
+	}
+	if !hasTrgm {
+		return echo.NewHTTPError(http.StatusNotImplemented,
+			"by=name requires the pg_trgm extension (CREATE EXTENSION pg_trgm)")
+	}
+
+	var pairs []struct{ A, B uint }
+	query := `SELECT a.id AS a, b.id AS b
+		FROM users a JOIN users b ON a.id < b.id AND similarity(a.name, b.name) >= ?
+		WHERE a.name <> '' AND b.name <> ''`
+	args := []interface{}{threshold}
+	// Raw SQL bypasses tenantScope and the soft-delete clause
+	for _, table := range []string{"a", "b"} {
+		cond, condArgs, err := userScopeSQL(c, table)
+		if err != nil {
+			return err
+		}
+		if cond != "" {
+			query += " AND " + cond
+			args = append(args, condArgs...)
+		}
+	}
+	err = dbFor(c).Raw(query, args...).Scan(&pairs).Error
+	if err != nil {
+		return dbError(err)
+	}
+
+	// Union-find over the pairs; every root ends up as the cluster's lowest ID
+	parent := map[uint]uint{}
+	var find func(uint) uint
+	find = func(id uint) uint {
+		if up, ok := parent[id]; ok && up != id {
+			parent[id] = find(up)
+			return parent[id]
+		}
+		parent[id] = id
+		return id
+	}
+	for _, pair := range pairs {
+		a, b := find(pair.A), find(pair.B)
+		if a > b {
+			a, b = b, a
+		}
+		parent[b] = a
+	}
+	members := map[uint][]uint{}
+	for id := range parent {
+		root := find(id)
+		members[root] = append(members[root], id)
+	}
+	roots := make([]uint, 0, len(members))
+	for root := range members {
+		roots = append(roots, root)
+	}
+	sort.Slice(roots, func(i, j int) bool { return roots[i] < roots[j] })
+
+	clusters := []DuplicateCluster{}
+	if p.Offset() >= len(roots) {
+		return c.JSON(http.StatusOK, clusters)
+	}
+	roots = roots[p.Offset():min(p.Offset()+p.PageSize, len(roots))]
+
+	var ids []uint
+	for _, root := range roots {
+		ids = append(ids, members[root]...)
+	}
+	q, err := scopeUsers(c, dbFor(c))
+	if err != nil {
+		return err
+	}
+	var users []User
+	if err := q.Order("id").Find(&users, ids).Error; err != nil {
+		return dbError(err)
+	}
+	byRoot := make(map[uint][]User, len(roots))
+	for _, u := range users {
+		root := find(u.ID)
+		byRoot[root] = append(byRoot[root], u)
+	}
+	for _, root := range roots {
+		if group := byRoot[root]; len(group) > 0 {
+			clusters = append(clusters, DuplicateCluster{Key: group[0].Name, Users: group})
+		}
+	}
+	return c.JSON(http.StatusOK, clusters)
+}
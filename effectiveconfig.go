@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm/schema"
+)
+
+// redacted replaces the value of every secret setting that is set
+const redacted = "[REDACTED]"
+
+// effectiveConfig returns cfg keyed by snake_case field name. Secret
+// settings (strings or byte slices) become redacted, or "" when unset;
+// durations are rendered as strings and flag sets as sorted lists.
+func effectiveConfig() map[string]interface{} {
+	names := schema.NamingStrategy{}
+	v := reflect.ValueOf(cfg)
+	out := make(map[string]interface{}, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		key := names.ColumnName("", field.Name)
+		if field.Tag.Get("config") == "secret" {
+			out[key] = ""
+			if value.Len() > 0 {
+				out[key] = redacted
+			}
+			continue
+		}
+		switch x := value.Interface().(type) {
+		case time.Duration:
+			out[key] = x.String()
+		case []*net.IPNet:
+			cidrs := make([]string, len(x))
+			for i, n := range x {
+				cidrs[i] = n.String()
+			}
+			out[key] = cidrs
+		case map[string]bool:
+			enabled := []string{}
+			for name, on := range x {
+				if on {
+					enabled = append(enabled, name)
+				}
+			}
+			sort.Strings(enabled)
+			out[key] = enabled
+		default:
+			out[key] = x
+		}
+	}
+	return out
+}
+
+// @Summary Get the effective configuration
+// @Description Report the settings this instance is running with, after defaults and validation, keyed by snake_case name (e.g. db_max_open_conns for DB_MAX_OPEN_CONNS). Secrets (database password, admin API key, signing keys, webhook URL and secret) are never included: they show as [REDACTED] when set and "" when not. Requires the admin API key.
+// @Tags admin
+// @Produce json
+// @Security AdminKey
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/config [get]
+func getEffectiveConfig(c echo.Context) error {
+	return c.JSON(http.StatusOK, effectiveConfig())
+}
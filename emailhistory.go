@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// UserEmail is an email address a user had before changing it
+type UserEmail struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"not null;index"`
+	Email      string    `json:"email" gorm:"size:255;not null;index"`
+	ReplacedAt time.Time `json:"replaced_at" gorm:"autoCreateTime"`
+}
+
+// recordEmailChange saves user's current email to the history when it is
+// about to be replaced by a different one.
+func recordEmailChange(tx *gorm.DB, user *User, newEmail string) error {
+	if newEmail == "" || user.Email == "" || strings.EqualFold(user.Email, newEmail) {
+		return nil
+	}
+	return tx.Create(&UserEmail{UserID: user.ID, Email: user.Email}).Error
+}
+
+// @Summary Get a user's email history
+// @Description List the emails a user had before, most recently replaced first. Requires the admin API key.
+// @Tags admin
+// @Produce json
+// @Security AdminKey
+// @Param id path int true "User ID"
+// @Success 200 {array} UserEmail
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/email-history [get]
+func getEmailHistory(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	// Deleted users keep their history for investigations
+	var user User
+	if err := dbFor(c).Unscoped().First(&user, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return newCodedError(http.StatusNotFound, CodeUserNotFound, "User not found")
+		}
+		return dbError(err)
+	}
+
+	history := []UserEmail{}
+	if err := dbFor(c).Where("user_id = ?", id).Order("replaced_at DESC, id DESC").Find(&history).Error; err != nil {
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, history)
+}
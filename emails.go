@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EmailAddress is one of a user's email addresses. The primary address is
+// mirrored in User.Email, which stays the source of truth for lookups.
+type EmailAddress struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	Email     string    `json:"email" gorm:"size:255;not null;index"`
+	Primary   bool      `json:"primary" gorm:"column:is_primary;not null;default:false"`
+}
+
+// AddEmailRequest is the body of POST /users/{id}/emails
+type AddEmailRequest struct {
+	Email string `json:"email" example:"john.work@gmail.com" validate:"required,email,max=255"`
+}
+
+// seedPrimaryEmail stores a new user's email as their primary address.
+func seedPrimaryEmail(tx *gorm.DB, user *User) error {
+	return tx.Create(&EmailAddress{UserID: user.ID, Email: user.Email, Primary: true}).Error
+}
+
+// syncPrimaryEmail keeps the primary address in step with User.Email after
+// it is changed directly.
+func syncPrimaryEmail(tx *gorm.DB, userID uint, email string) error {
+	return tx.Model(&EmailAddress{}).Where("user_id = ? AND is_primary", userID).Update("email", email).Error
+}
+
+// userEmails lists user's addresses, primary first. Users created before
+// addresses were stored get their primary filled in from User.Email.
+func userEmails(q *gorm.DB, user *User) ([]EmailAddress, error) {
+	var emails []EmailAddress
+	if err := q.Where("user_id = ?", user.ID).Order("is_primary DESC, id").Find(&emails).Error; err != nil {
+		return nil, err
+	}
+	if len(emails) == 0 || !emails[0].Primary {
+		primary := EmailAddress{UserID: user.ID, Email: user.Email, Primary: true, CreatedAt: user.CreatedAt}
+		emails = append([]EmailAddress{primary}, emails...)
+	}
+	return emails, nil
+}
+
+// @Summary Add an email address
+// @Description Add a secondary email address to a user. Addresses must be unique across all users' primary and secondary emails.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param email body AddEmailRequest true "Address to add"
+// @Success 201 {object} EmailAddress
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/emails [post]
+func addUserEmail(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	req := new(AddEmailRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		if verrs, ok := err.(ValidationErrors); ok {
+			return validationError(verrs)
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var address EmailAddress
+	err = writeTx(c, func(tx *gorm.DB) error {
+		address = EmailAddress{UserID: id, Email: normalizeEmail(req.Email)}
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			return err
+		}
+		taken, err := emailTaken(tx, address.Email)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return newCodedError(http.StatusConflict, CodeEmailTaken, "Email already in use")
+		}
+		if err := tx.Create(&address).Error; err != nil {
+			return err
+		}
+		return touchUser(tx, id)
+	})
+	if err != nil {
+		return emailError(err)
+	}
+	return c.JSON(http.StatusCreated, address)
+}
+
+// @Summary Remove an email address
+// @Description Remove a secondary email address. The primary address cannot be removed; promote another one first.
+// @Tags user
+// @Produce json
+// @Param id path int true "User ID"
+// @Param email_id path int true "Email address ID"
+// @Success 200 {string} string "Email removed successfully"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/emails/{email_id} [delete]
+func removeUserEmail(c echo.Context) error {
+	id, emailID, err := parseEmailIDs(c)
+	if err != nil {
+		return err
+	}
+	err = writeTx(c, func(tx *gorm.DB) error {
+		// Load the user first so another tenant's addresses stay hidden
+		if err := tx.Select("id").First(&User{}, id).Error; err != nil {
+			return err
+		}
+		var address EmailAddress
+		if err := tx.Where("user_id = ?", id).First(&address, emailID).Error; err != nil {
+			return err
+		}
+		if address.Primary {
+			return newCodedError(http.StatusConflict, CodePrimaryEmailRequired, "The primary email cannot be removed")
+		}
+		if err := tx.Delete(&address).Error; err != nil {
+			return err
+		}
+		return touchUser(tx, id)
+	})
+	if err != nil {
+		return emailError(err)
+	}
+	return c.JSON(http.StatusOK, "Email removed successfully")
+}
+
+// @Summary Promote an email address
+// @Description Make a secondary email address the user's primary one. The previous primary address is kept as a secondary address.
+// @Tags user
+// @Produce json
+// @Param id path int true "User ID"
+// @Param email_id path int true "Email address ID"
+// @Success 200 {object} User
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/emails/{email_id}/promote [post]
+func promoteUserEmail(c echo.Context) error {
+	id, emailID, err := parseEmailIDs(c)
+	if err != nil {
+		return err
+	}
+
+	var user User
+	err = writeTx(c, func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			return err
+		}
+		var address EmailAddress
+		if err := tx.Where("user_id = ?", id).First(&address, emailID).Error; err != nil {
+			return err
+		}
+		if address.Primary {
+			return nil
+		}
+
+		// Demote the current primary, storing it first for legacy users
+		res := tx.Model(&EmailAddress{}).Where("user_id = ? AND is_primary", id).Update("is_primary", false)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			if err := tx.Create(&EmailAddress{UserID: id, Email: user.Email}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&address).Update("is_primary", true).Error; err != nil {
+			return err
+		}
+		if err := recordEmailChange(tx, &user, address.Email); err != nil {
+			return err
+		}
+		return tx.Model(&user).Update("email", address.Email).Error
+	})
+	if err != nil {
+		return emailError(err)
+	}
+	if user.Emails, err = userEmails(dbFor(c), &user); err != nil {
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// @Summary Get a user by email
+// @Description Find the user owning an email address, primary or secondary. The email is normalized first, and the same filter params as GET /users apply. Rate limited per client IP (shared with /users/email-available) and requires the admin API key.
+// @Tags admin
+// @Produce json
+// @Security AdminKey
+// @Param email query string true "Email to look up"
+// @Success 200 {object} User
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/by-email [get]
+func getUserByEmail(c echo.Context) error {
+	email := normalizeEmail(c.QueryParam("email"))
+	if !isValidEmail(email) {
+		return newCodedError(http.StatusBadRequest, CodeValidationFailed, "email must be a valid email address")
+	}
+
+	q, err := applyScopes(c, dbFor(c))
+	if err != nil {
+		return err
+	}
+	var user User
+	err = q.Where("LOWER(email) = ?", email).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		if q, err = applyScopes(c, dbFor(c)); err != nil {
+			return err
+		}
+		err = q.
+			Where("id IN (?)", dbFor(c).Model(&EmailAddress{}).Select("user_id").Where("LOWER(email) = ?", email)).
+			First(&user).Error
+	}
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return newCodedError(http.StatusNotFound, CodeUserNotFound, "User not found")
+		}
+		return dbError(err)
+	}
+	if user.Emails, err = userEmails(dbFor(c), &user); err != nil {
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// touchUser bumps updated_at so the user's ETag changes along with its
+// email list.
+func touchUser(tx *gorm.DB, id uint) error {
+	return tx.Model(&User{}).Where("id = ?", id).Update("updated_at", time.Now()).Error
+}
+
+func parseEmailIDs(c echo.Context) (uint, uint, error) {
+	id, err := parseID(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	emailID, err := strconv.ParseUint(c.Param("email_id"), 10, 0)
+	if err != nil || emailID == 0 {
+		return 0, 0, newCodedError(http.StatusBadRequest, CodeInvalidID, "Invalid email ID")
+	}
+	return id, uint(emailID), nil
+}
+
+// emailError maps errors from the email address handlers to responses.
+func emailError(err error) error {
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he
+	}
+	if err == gorm.ErrRecordNotFound {
+		return newCodedError(http.StatusNotFound, CodeUserNotFound, "User or email not found")
+	}
+	return dbError(err)
+}
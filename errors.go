@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Error codes returned in the code field of every error response. They are
+// stable, so clients can branch on them instead of on messages or statuses.
+const (
+	CodeValidationFailed     = "VALIDATION_FAILED"
+	CodeInvalidRequest       = "INVALID_REQUEST"
+	CodeInvalidID            = "INVALID_ID"
+	CodeInvalidCursor        = "INVALID_CURSOR"
+	CodeQueryTooExpensive    = "QUERY_TOO_EXPENSIVE"
+	CodeMalformedBody        = "MALFORMED_BODY"
+	CodeInvalidHost          = "INVALID_HOST"
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeForbidden            = "FORBIDDEN"
+	CodeAdminDisabled        = "ADMIN_DISABLED"
+	CodeClientIDRequired     = "CLIENT_ID_REQUIRED"
+	CodeUnknownClient        = "UNKNOWN_CLIENT"
+	CodeTenantRequired       = "TENANT_REQUIRED"
+	CodeNotFound             = "NOT_FOUND"
+	CodeUserNotFound         = "USER_NOT_FOUND"
+	CodeExportNotFound       = "EXPORT_NOT_FOUND"
+	CodeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	CodeConflict             = "CONFLICT"
+	CodeEmailTaken           = "EMAIL_TAKEN"
+	CodeConcurrentUpdate     = "CONCURRENT_UPDATE"
+	CodeDuplicateRequest     = "DUPLICATE_REQUEST"
+	CodeUserNotDeleted       = "USER_NOT_DELETED"
+	CodePrimaryEmailRequired = "PRIMARY_EMAIL_REQUIRED"
+	CodeGone                 = "GONE"
+	CodeUndoWindowExpired    = "UNDO_WINDOW_EXPIRED"
+	CodePreconditionFailed   = "PRECONDITION_FAILED"
+	CodePayloadTooLarge      = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodePreconditionRequired = "PRECONDITION_REQUIRED"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeInternalError        = "INTERNAL_ERROR"
+	CodeNotImplemented       = "NOT_IMPLEMENTED"
+	CodeServiceUnavailable   = "SERVICE_UNAVAILABLE"
+	CodeServerBusy           = "SERVER_BUSY"
+	CodeDatabaseUnavailable  = "DATABASE_UNAVAILABLE"
+	CodeDatabaseTimeout      = "DATABASE_TIMEOUT"
+	CodeTimeout              = "TIMEOUT"
+)
+
+// ErrorResponse is the body of every error response. Validation failures
+// list the failed rules under errors; failed batch operations report their
+// index.
+type ErrorResponse struct {
+	Code    string       `json:"code" example:"USER_NOT_FOUND" enums:"VALIDATION_FAILED,INVALID_REQUEST,INVALID_ID,INVALID_CURSOR,QUERY_TOO_EXPENSIVE,MALFORMED_BODY,INVALID_HOST,UNAUTHORIZED,FORBIDDEN,ADMIN_DISABLED,CLIENT_ID_REQUIRED,UNKNOWN_CLIENT,TENANT_REQUIRED,NOT_FOUND,USER_NOT_FOUND,EXPORT_NOT_FOUND,METHOD_NOT_ALLOWED,CONFLICT,EMAIL_TAKEN,CONCURRENT_UPDATE,DUPLICATE_REQUEST,USER_NOT_DELETED,PRIMARY_EMAIL_REQUIRED,GONE,UNDO_WINDOW_EXPIRED,PRECONDITION_FAILED,PAYLOAD_TOO_LARGE,UNSUPPORTED_MEDIA_TYPE,PRECONDITION_REQUIRED,RATE_LIMITED,INTERNAL_ERROR,NOT_IMPLEMENTED,SERVICE_UNAVAILABLE,SERVER_BUSY,DATABASE_UNAVAILABLE,DATABASE_TIMEOUT,TIMEOUT"`
+	Message string       `json:"message" example:"User not found"`
+	Errors  []FieldError `json:"errors,omitempty"`
+	Index   *int         `json:"index,omitempty"`
+}
+
+// MIMEApplicationProblemJSON is the RFC 7807 problem details media type
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// ProblemDetails is the RFC 7807 error body sent to clients that accept
+// application/problem+json. Code, Errors and Index are extension members
+// carrying the same values as in ErrorResponse.
+type ProblemDetails struct {
+	Type     string       `json:"type" example:"/problems/user-not-found"`
+	Title    string       `json:"title" example:"Not Found"`
+	Status   int          `json:"status" example:"404"`
+	Detail   string       `json:"detail" example:"User not found"`
+	Instance string       `json:"instance" example:"/user/42"`
+	Code     string       `json:"code" example:"USER_NOT_FOUND"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	Index    *int         `json:"index,omitempty"`
+}
+
+// problemType returns the problem type URI for an error code, e.g.
+// USER_NOT_FOUND becomes PROBLEM_TYPE_BASE + "user-not-found".
+func problemType(code string) string {
+	return cfg.ProblemTypeBase + strings.ToLower(strings.ReplaceAll(code, "_", "-"))
+}
+
+// newProblemDetails builds the problem details for an error with the given
+// status, code and (translated) message.
+func newProblemDetails(c echo.Context, status int, code string, message interface{}) ProblemDetails {
+	p := ProblemDetails{
+		Type:     problemType(code),
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: c.Request().URL.Path,
+		Code:     code,
+	}
+	for {
+		m, ok := message.(map[string]interface{})
+		if !ok {
+			break
+		}
+		if errs, ok := m["errors"].(ValidationErrors); ok {
+			p.Errors = errs
+		}
+		if index, ok := m["index"].(int); ok {
+			p.Index = &index
+		}
+		message = m["message"]
+	}
+	p.Detail = fmt.Sprint(message)
+	return p
+}
+
+// wantsProblemJSON reports whether the client asked for RFC 7807 errors.
+func wantsProblemJSON(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), MIMEApplicationProblemJSON)
+}
+
+// codedError carries the code of an HTTPError made by newCodedError. It is
+// stored as the HTTPError's Internal error, so the code stays the same
+// however the message is worded or translated.
+type codedError struct {
+	code string
+}
+
+func (e codedError) Error() string { return e.code }
+
+// newCodedError returns an HTTPError with the given status, error code and
+// message.
+func newCodedError(status int, code string, message interface{}) *echo.HTTPError {
+	return echo.NewHTTPError(status, message).SetInternal(codedError{code})
+}
+
+// statusCodes gives the code for errors without a specific one.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusGone:                  CodeGone,
+	http.StatusPreconditionFailed:    CodePreconditionFailed,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusPreconditionRequired:  CodePreconditionRequired,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternalError,
+	http.StatusNotImplemented:        CodeNotImplemented,
+	http.StatusServiceUnavailable:    CodeServiceUnavailable,
+	http.StatusGatewayTimeout:        CodeTimeout,
+}
+
+// errorCode returns the code he was created with by newCodedError, or the
+// generic code for its status.
+func errorCode(he *echo.HTTPError) string {
+	if ce, ok := he.Internal.(codedError); ok {
+		return ce.code
+	}
+	if code, ok := statusCodes[he.Code]; ok {
+		return code
+	}
+	if he.Code >= 500 {
+		return CodeInternalError
+	}
+	return CodeInvalidRequest
+}
+
+// asHTTPError converts an error from a user operation into the HTTP error
+// it should produce.
+func asHTTPError(err error) *echo.HTTPError {
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he
+	}
+	if err == gorm.ErrRecordNotFound {
+		return newCodedError(http.StatusNotFound, CodeUserNotFound, "User not found")
+	}
+	return dbError(err)
+}
+
+// errorResponse builds the error body for he, for places that report
+// several errors in one response rather than going through errorHandler.
+func errorResponse(he *echo.HTTPError) ErrorResponse {
+	res := ErrorResponse{Code: errorCode(he)}
+	switch m := he.Message.(type) {
+	case map[string]interface{}:
+		res.Message = fmt.Sprint(m["message"])
+		res.Errors, _ = m["errors"].(ValidationErrors)
+	default:
+		res.Message = fmt.Sprint(m)
+	}
+	return res
+}
+
+// errorHandler is the central HTTP error handler. Every error body gets a
+// code, and messages are translated into the language requested by
+// Accept-Language. Clients accepting application/problem+json get RFC 7807
+// problem details instead of ErrorResponse.
+func errorHandler(e *echo.Echo) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			return
+		}
+		var he *echo.HTTPError
+		if !errors.As(err, &he) {
+			// Unexpected errors are logged, not shown to clients
+			c.Logger().Error(err)
+			he = echo.NewHTTPError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		if inner, ok := he.Internal.(*echo.HTTPError); ok {
+			he = inner
+		}
+
+		lang := preferredLanguage(c.Request().Header.Get("Accept-Language"))
+		res := c.Response()
+		res.Header().Add(echo.HeaderVary, "Accept-Language")
+		res.Header().Set("Content-Language", lang)
+
+		code := errorCode(he)
+		res.Header().Add(echo.HeaderVary, echo.HeaderAccept)
+		var body interface{}
+		message := localizeMessage(lang, he.Message)
+		if wantsProblemJSON(c) {
+			// c.JSON keeps a Content-Type that is already set
+			res.Header().Set(echo.HeaderContentType, MIMEApplicationProblemJSON)
+			body = newProblemDetails(c, he.Code, code, message)
+		} else {
+			switch m := message.(type) {
+			case map[string]interface{}:
+				m["code"] = code
+				body = m
+			case error:
+				body = ErrorResponse{Code: code, Message: m.Error()}
+			default:
+				body = ErrorResponse{Code: code, Message: fmt.Sprint(m)}
+			}
+		}
+
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(he.Code)
+		} else {
+			err = c.JSON(he.Code, body)
+		}
+		if err != nil {
+			e.Logger.Error(err)
+		}
+	}
+}
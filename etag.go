@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// userETag is a strong ETag for the current version of user. UpdatedAt
+// changes on every write, so it identifies the version.
+func userETag(user *User) string {
+	return fmt.Sprintf(`"%d-%x"`, user.ID, user.UpdatedAt.UnixNano())
+}
+
+// etagListContains reports whether a comma-separated If-Match header lists
+// etag, or is "*". This is the strong comparison: weak tags never match.
+func etagListContains(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagListMatchesWeak is etagListContains with the weak comparison RFC 9110
+// requires for If-None-Match: tags match when they are equal apart from a
+// W/ prefix.
+func etagListMatchesWeak(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces the request's If-Match header against user, the
+// HTTP form of optimistic locking. With REQUIRE_IF_MATCH, requests without
+// the header are rejected with 428 so clients cannot skip the check.
+func checkIfMatch(c echo.Context, user *User) error {
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		if cfg.RequireIfMatch {
+			return echo.NewHTTPError(http.StatusPreconditionRequired, "If-Match header is required")
+		}
+		return nil
+	}
+	if !etagListContains(header, userETag(user)) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "User has been modified; fetch it again")
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Export job statuses
+const (
+	exportPending = "pending"
+	exportRunning = "running"
+	exportDone    = "done"
+	exportFailed  = "failed"
+)
+
+// ExportJob is a CSV export generated in the background by runExportWorker
+type ExportJob struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Status      string     `json:"status" gorm:"size:16;not null;index;default:pending"`
+	Filters     string     `json:"filters" gorm:"type:text"`
+	Rows        int64      `json:"rows"`
+	Error       string     `json:"error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"index"`
+	TenantID    string     `json:"tenant_id,omitempty" gorm:"size:64;not null;default:'';index"`
+	FilePath    string     `json:"-"`
+	DownloadURL string     `json:"download_url,omitempty" gorm:"-"`
+}
+
+// withDownloadURL sets DownloadURL once the file is ready.
+func (j *ExportJob) withDownloadURL() *ExportJob {
+	if j.Status == exportDone {
+		j.DownloadURL = fmt.Sprintf("/exports/%d/download", j.ID)
+	}
+	return j
+}
+
+// @Summary Start an export job
+// @Description Queue a CSV export of all users matching the filter params (same as GET /users/export). Poll GET /exports/{id} until it is done, then download it from download_url.
+// @Tags exports
+// @Produce json
+// @Success 202 {object} ExportJob
+// @Header 202 {string} Location "URL of the job"
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /exports [post]
+func createExportJob(c echo.Context) error {
+	filters := url.Values{}
+	for key, values := range c.QueryParams() {
+		if filterParamPattern.MatchString(key) {
+			filters[key] = values
+		}
+	}
+	// Reject bad filters now rather than failing the job later
+	if _, err := applyFilterParams(filters, db); err != nil {
+		return err
+	}
+
+	job := ExportJob{
+		Status:    exportPending,
+		Filters:   filters.Encode(),
+		ExpiresAt: time.Now().Add(cfg.ExportTTL),
+	}
+	if err := dbFor(c).Create(&job).Error; err != nil {
+		return dbError(err)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/exports/%d", job.ID))
+	return c.JSON(http.StatusAccepted, job.withDownloadURL())
+}
+
+// @Summary Get an export job
+// @Description Report the status of an export job (pending, running, done or failed). Done jobs include a download_url until they expire.
+// @Tags exports
+// @Produce json
+// @Param id path int true "Job ID"
+// @Success 200 {object} ExportJob
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /exports/{id} [get]
+func getExportJob(c echo.Context) error {
+	job, err := findExportJob(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, job.withDownloadURL())
+}
+
+// @Summary Download an export
+// @Description Download the CSV produced by a finished export job. The file never changes once written, so interrupted downloads can be resumed with a Range header (206 Partial Content, or 416 when the range is unsatisfiable). The weak ETag supports If-None-Match; use Last-Modified for If-Range.
+// @Tags exports
+// @Produce text/csv
+// @Param id path int true "Job ID"
+// @Param Range header string false "Byte range to download, e.g. bytes=1048576-"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Header 200 {string} ETag "Weak ETag of the export file"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 416 {string} string "Requested range not satisfiable"
+// @Failure 500 {object} ErrorResponse
+// @Router /exports/{id}/download [get]
+func downloadExport(c echo.Context) error {
+	job, err := findExportJob(c)
+	if err != nil {
+		return err
+	}
+	if job.Status != exportDone {
+		return echo.NewHTTPError(http.StatusConflict, "Export is "+job.Status)
+	}
+	// Attachment serves the file with http.ServeContent, which answers Range,
+	// If-Range and If-None-Match (against this ETag) on its own
+	c.Response().Header().Set("ETag", fmt.Sprintf(`W/"export-%d-%x"`, job.ID, job.CompletedAt.UnixNano()))
+	return c.Attachment(job.FilePath, fmt.Sprintf("users-export-%d.csv", job.ID))
+}
+
+func findExportJob(c echo.Context) (*ExportJob, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil || id == 0 {
+		return nil, newCodedError(http.StatusBadRequest, CodeInvalidID, "Invalid export job ID")
+	}
+	var job ExportJob
+	if err := dbFor(c).First(&job, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, newCodedError(http.StatusNotFound, CodeExportNotFound, "Export job not found")
+		}
+		return nil, dbError(err)
+	}
+	return &job, nil
+}
+
+// runExportWorker generates pending export jobs one at a time and removes
+// expired ones, until ctx is cancelled.
+func runExportWorker(ctx context.Context) {
+	if err := os.MkdirAll(cfg.ExportDir, 0o700); err != nil {
+		log.Printf("Export worker disabled: %v", err)
+		return
+	}
+	ticker := time.NewTicker(cfg.ExportPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cleanupExportJobs(ctx); err != nil {
+				log.Printf("Export cleanup failed: %v", err)
+			}
+			for {
+				job, err := claimExportJob(ctx)
+				if err != nil {
+					log.Printf("Export worker failed: %v", err)
+					break
+				}
+				if job == nil {
+					break
+				}
+				runExportJob(ctx, job)
+			}
+		}
+	}
+}
+
+// claimExportJob marks the oldest pending job as running and returns it, or
+// nil when there is none. SKIP LOCKED lets several instances share the queue.
+func claimExportJob(ctx context.Context) (*ExportJob, error) {
+	var job ExportJob
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", exportPending).
+			Order("id").
+			First(&job).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&job).Update("status", exportRunning).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// runExportJob writes the job's CSV and records the outcome.
+func runExportJob(ctx context.Context, job *ExportJob) {
+	path := filepath.Join(cfg.ExportDir, fmt.Sprintf("export-%d.csv", job.ID))
+	exportCtx := ctx
+	if cfg.MultiTenant {
+		// Export only the users of the tenant that asked for it
+		exportCtx = withTenant(ctx, job.TenantID)
+	}
+	rows, err := writeExportFile(exportCtx, job, path)
+
+	now := time.Now()
+	updates := map[string]interface{}{"completed_at": now, "rows": rows}
+	if err != nil {
+		log.Printf("Export job %d failed: %v", job.ID, err)
+		updates["status"] = exportFailed
+		updates["error"] = err.Error()
+	} else {
+		updates["status"] = exportDone
+		updates["file_path"] = path
+		updates["expires_at"] = now.Add(cfg.ExportTTL)
+	}
+	if err := db.WithContext(ctx).Model(job).Updates(updates).Error; err != nil {
+		log.Printf("Failed to update export job %d: %v", job.ID, err)
+	}
+}
+
+// writeExportFile writes the CSV to a temporary file and renames it into
+// place, so a download never sees a partial file.
+func writeExportFile(ctx context.Context, job *ExportJob, path string) (int64, error) {
+	filters, err := url.ParseQuery(job.Filters)
+	if err != nil {
+		return 0, err
+	}
+	q, err := applyFilterParams(filters, db.WithContext(ctx).Model(&User{}))
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(cfg.ExportDir, "export-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	rows, err := q.Order("id").Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var n int64
+	cw := csv.NewWriter(f)
+	if err := cw.Write(userCSVHeader); err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var u User
+		if err := db.ScanRows(rows, &u); err != nil {
+			return n, err
+		}
+		if err := cw.Write(userCSVRecord(u)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return n, err
+	}
+	if err := f.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Rename(f.Name(), path)
+}
+
+// cleanupExportJobs deletes expired jobs along with their files. Jobs left
+// running by a crashed worker expire too, since ExpiresAt is set on creation.
+func cleanupExportJobs(ctx context.Context) error {
+	var jobs []ExportJob
+	err := db.WithContext(ctx).Where("expires_at < ?", time.Now()).Find(&jobs).Error
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.FilePath != "" {
+			if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := db.WithContext(ctx).Delete(&job).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// featureEnabled reports whether name is listed in FEATURES.
+func featureEnabled(name string) bool {
+	return cfg.Features[name]
+}
+
+// requireFeature returns middleware that answers 404, exactly like an
+// unknown route, unless the named feature is enabled.
+func requireFeature(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !featureEnabled(name) {
+				return echo.ErrNotFound
+			}
+			return next(c)
+		}
+	}
+}
+
+// featureRoute registers a route that only exists while feature is enabled,
+// so new endpoints can ship dark and be turned on per environment.
+func featureRoute(e *echo.Echo, feature, method, path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return e.Add(method, path, h, append([]echo.MiddlewareFunc{requireFeature(feature)}, m...)...)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// scopeFixture holds users that every read endpoint must tell apart: only
+// ann is a live, active user of tenant acme. Their names are alike, so
+// by=name duplicates would cluster all of them if a scope were missing.
+type scopeFixture struct {
+	ann, bob, dee, gus User
+}
+
+func newScopeFixture(t *testing.T) scopeFixture {
+	t.Helper()
+	create := func(tenant, name, email, status string) User {
+		u := User{TenantID: tenant, Name: name, Email: email, Status: status}
+		if err := db.Create(&u).Error; err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	var f scopeFixture
+	// dee is deleted, which frees ann's email within acme
+	f.dee = create("acme", "Acme Dee", "ann@example.com", "active")
+	if err := db.Delete(&f.dee).Error; err != nil {
+		t.Fatal(err)
+	}
+	f.ann = create("acme", "Acme Ann", "ann@example.com", "active")
+	f.bob = create("acme", "Acme Bob", "bob@example.com", "suspended")
+	f.gus = create("globex", "Acme Gus", "ann@example.com", "active")
+	return f
+}
+
+// scopedServer serves the read endpoints the way main does, with tenants
+// resolved from TENANT_HEADER.
+func scopedServer() *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = errorHandler(e)
+	e.Use(resolveTenant(cfg.TenantHeader))
+	e.GET("/users", getUsers)
+	e.GET("/users/stats", getUserStats)
+	e.GET("/users/timeline", getUserTimeline)
+	e.GET("/users/count", getUserCount)
+	e.GET("/users/recent", getRecentUsers)
+	e.GET("/users/batch", getUsersBatch)
+	e.GET("/users/export", exportUsers)
+	e.GET("/users/duplicates", getDuplicateUsers)
+	e.GET("/users/by-email", getUserByEmail)
+	e.GET("/user/:id", getUserHandler)
+	e.GET("/users/:id/email-history", getEmailHistory)
+	return e
+}
+
+// countIn sums the count fields found anywhere in a JSON body.
+func countIn(t *testing.T, body []byte) int64 {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	var sum func(v interface{}) int64
+	sum = func(v interface{}) int64 {
+		var n int64
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, x := range v {
+				if f, ok := x.(float64); ok && k == "count" {
+					n += int64(f)
+				} else {
+					n += sum(x)
+				}
+			}
+		case []interface{}:
+			for _, x := range v {
+				n += sum(x)
+			}
+		}
+		return n
+	}
+	return sum(v)
+}
+
+func TestReadEndpointsApplyScopes(t *testing.T) {
+	requireDB(t)
+	f := newScopeFixture(t)
+	e := scopedServer()
+	names := []string{f.ann.Name, f.bob.Name, f.dee.Name, f.gus.Name}
+
+	tests := []struct {
+		name   string
+		target string
+		// Users whose names the body must contain; all others must be absent
+		visible []string
+		// count, when set, is the total of the body's count fields instead
+		count *int64
+		// trgm marks cases that need the pg_trgm extension
+		trgm bool
+	}{
+		{name: "list", target: "/users?filter[status]=active", visible: []string{f.ann.Name}},
+		{name: "list all statuses", target: "/users", visible: []string{f.ann.Name, f.bob.Name}},
+		{name: "export", target: "/users/export?filter[status]=active", visible: []string{f.ann.Name}},
+		{name: "count", target: "/users/count?filter[status]=active", count: ptr(int64(1))},
+		{name: "count all statuses", target: "/users/count", count: ptr(int64(2))},
+		{name: "stats", target: "/users/stats?group_by=status&filter[status]=active", count: ptr(int64(1))},
+		{name: "timeline", target: "/users/timeline?filter[status]=active", count: ptr(int64(1))},
+		{name: "duplicates", target: "/users/duplicates", visible: []string{}},
+		{name: "duplicates by name", target: "/users/duplicates?by=name&similarity=0.2",
+			visible: []string{f.ann.Name, f.bob.Name}, trgm: true},
+		{name: "recent", target: "/users/recent?since=2000-01-01&filter[status]=active", visible: []string{f.ann.Name}},
+		{name: "recent all statuses", target: "/users/recent?since=2000-01-01", visible: []string{f.ann.Name, f.bob.Name}},
+		{name: "batch", target: fmt.Sprintf("/users/batch?ids=%d,%d,%d,%d&filter[status]=active", f.ann.ID, f.bob.ID, f.dee.ID, f.gus.ID),
+			visible: []string{f.ann.Name}},
+		{name: "batch all statuses", target: fmt.Sprintf("/users/batch?ids=%d,%d,%d,%d", f.ann.ID, f.bob.ID, f.dee.ID, f.gus.ID),
+			visible: []string{f.ann.Name, f.bob.Name}},
+		{name: "by email", target: "/users/by-email?email=ann@example.com&filter[status]=active", visible: []string{f.ann.Name}},
+		{name: "by email all statuses", target: "/users/by-email?email=bob@example.com", visible: []string{f.bob.Name}},
+		{name: "by id", target: fmt.Sprintf("/user/%d", f.ann.ID), visible: []string{f.ann.Name}},
+		{name: "email history", target: fmt.Sprintf("/users/%d/email-history", f.ann.ID), visible: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(e, tt.target); rec.Code != http.StatusBadRequest {
+				t.Errorf("without a tenant: status %d, want 400: %s", rec.Code, rec.Body)
+			}
+			if tt.trgm && !hasTrgm(t) {
+				t.Skip("pg_trgm is not installed")
+			}
+
+			rec := serve(e, tt.target, cfg.TenantHeader, "acme")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			body := rec.Body.String()
+			if tt.count != nil {
+				if got := countIn(t, rec.Body.Bytes()); got != *tt.count {
+					t.Errorf("count %d, want %d: %s", got, *tt.count, body)
+				}
+				return
+			}
+			want := map[string]bool{}
+			for _, name := range tt.visible {
+				want[name] = true
+			}
+			for _, name := range names {
+				if got := strings.Contains(body, name); got != want[name] {
+					t.Errorf("%s visible = %v, want %v: %s", name, got, want[name], body)
+				}
+			}
+		})
+	}
+}
+
+func TestReadEndpointsHideUsersOutOfScope(t *testing.T) {
+	requireDB(t)
+	f := newScopeFixture(t)
+	e := scopedServer()
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{fmt.Sprintf("/user/%d", f.dee.ID), http.StatusNotFound},
+		{fmt.Sprintf("/user/%d", f.gus.ID), http.StatusNotFound},
+		{fmt.Sprintf("/users/%d/email-history", f.gus.ID), http.StatusNotFound},
+		{"/users/by-email?email=bob@example.com&filter[status]=active", http.StatusNotFound},
+		// Deleted users keep their history, but only within their tenant
+		{fmt.Sprintf("/users/%d/email-history", f.dee.ID), http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := serve(e, tt.target, cfg.TenantHeader, "acme"); rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.target, rec.Code, tt.want, rec.Body)
+		}
+	}
+	// The other tenant sees only its own user
+	rec := serve(e, "/users", cfg.TenantHeader, "globex")
+	var users []User
+	if err := json.Unmarshal(rec.Body.Bytes(), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != f.gus.ID {
+		t.Errorf("globex sees %+v, want only %s", users, f.gus.Name)
+	}
+}
+
+// hasTrgm reports whether the test database has the pg_trgm extension.
+func hasTrgm(t *testing.T) bool {
+	t.Helper()
+	var ok bool
+	err := db.Raw("SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm')").Scan(&ok).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func ptr[T any](v T) *T { return &v }
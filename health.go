@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HealthResponse reports database connectivity and whether the schema
+// matches what this binary expects
+type HealthResponse struct {
+	Status   string       `json:"status" example:"ok"`
+	Database string       `json:"database" example:"ok"`
+	Schema   string       `json:"schema" example:"ok"`
+	Drift    *SchemaDrift `json:"drift,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// @Summary Health check
+// @Description Ping the database and compare the schema against the models. Returns 503 while the server is still starting up (for example warming up the connection pool), when the database is unreachable or the schema has drifted (for example when a migration has not been run).
+// @Tags health
+// @Produce json
+// @Success 200 {object} HealthResponse
+// @Failure 503 {object} HealthResponse
+// @Router /healthz [get]
+func healthz(c echo.Context) error {
+	res := HealthResponse{Status: "ok", Database: "ok", Schema: "ok"}
+	if !ready.Load() {
+		res.Status = "starting"
+		return c.JSON(http.StatusServiceUnavailable, res)
+	}
+
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(c.Request().Context())
+	}
+	if err != nil {
+		res.Status, res.Database, res.Schema, res.Error = "unavailable", "unreachable", "unknown", err.Error()
+		return c.JSON(http.StatusServiceUnavailable, res)
+	}
+
+	drift, err := schemaDrift()
+	if err != nil {
+		res.Status, res.Schema, res.Error = "unavailable", "unknown", err.Error()
+		return c.JSON(http.StatusServiceUnavailable, res)
+	}
+	if drift.HasDrift() {
+		res.Status, res.Schema, res.Drift = "unavailable", "drift", &drift
+		return c.JSON(http.StatusServiceUnavailable, res)
+	}
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used when Accept-Language names no supported language
+const defaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs maps a language to its messages. Plain error messages are keyed by
+// their English text; validation rules by "validation.<rule>", with {field}
+// style placeholders.
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		log.Fatalf("Failed to read message catalogs: %v", err)
+	}
+	catalogs := map[string]map[string]string{}
+	for _, f := range files {
+		data, err := localeFiles.ReadFile("locales/" + f.Name())
+		if err != nil {
+			log.Fatalf("Failed to read message catalog %s: %v", f.Name(), err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			log.Fatalf("Invalid message catalog %s: %v", f.Name(), err)
+		}
+		catalogs[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = messages
+	}
+	return catalogs
+}
+
+// preferredLanguage picks the supported language the client ranks highest
+// in Accept-Language, ignoring region subtags.
+func preferredLanguage(header string) string {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if _, ok := catalogs[lang]; ok && q > 0 {
+			choices = append(choices, choice{lang, q})
+		}
+	}
+	if len(choices) == 0 {
+		return defaultLanguage
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	return choices[0].lang
+}
+
+// translate returns the message for key in lang, falling back to English
+// and then to key itself, with {name} placeholders filled from args.
+func translate(lang, key string, args map[string]string) string {
+	msg, ok := catalogs[lang][key]
+	if !ok {
+		if msg, ok = catalogs[defaultLanguage][key]; !ok {
+			msg = key
+		}
+	}
+	for k, v := range args {
+		msg = strings.ReplaceAll(msg, "{"+k+"}", v)
+	}
+	return msg
+}
+
+// localize returns fe with its message translated into lang.
+func (fe FieldError) localize(lang string) FieldError {
+	if fe.args != nil {
+		fe.Message = translate(lang, "validation."+fe.Rule, fe.args)
+	}
+	return fe
+}
+
+// localizeMessage translates an HTTPError message: plain strings, and the
+// message and errors of validation failures. Other messages are returned
+// unchanged.
+func localizeMessage(lang string, message interface{}) interface{} {
+	switch m := message.(type) {
+	case string:
+		return translate(lang, m, nil)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			switch v := v.(type) {
+			case string:
+				out[k] = translate(lang, v, nil)
+			case ValidationErrors:
+				errs := make(ValidationErrors, len(v))
+				for i, fe := range v {
+					errs[i] = fe.localize(lang)
+				}
+				out[k] = errs
+			default:
+				out[k] = v
+			}
+		}
+		return out
+	}
+	return message
+}
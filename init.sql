@@ -1,8 +1,9 @@
 CREATE TABLE
     IF NOT EXISTS users (
         id SERIAL PRIMARY KEY,
-        name VARCHAR(255),
-        email VARCHAR(255),
+        name VARCHAR(255) NOT NULL,
+        email VARCHAR(255) NOT NULL,
+        status VARCHAR(32) NOT NULL DEFAULT 'active',
         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
         updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
     );
\ No newline at end of file
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MIMEApplicationJSONAPI is the media type defined by the JSON:API spec
+const MIMEApplicationJSONAPI = "application/vnd.api+json"
+
+type jsonAPIResource struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+type jsonAPIDocument struct {
+	Data interface{} `json:"data"`
+}
+
+// wantsJSONAPI reports whether the client asked for JSON:API documents.
+func wantsJSONAPI(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), MIMEApplicationJSONAPI)
+}
+
+// userResource converts a user into a JSON:API resource object. The
+// attributes are derived from the regular JSON encoding so they stay in
+// sync with the User model.
+func userResource(u User) (jsonAPIResource, error) {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return jsonAPIResource{}, err
+	}
+	var attrs map[string]interface{}
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return jsonAPIResource{}, err
+	}
+	delete(attrs, "id")
+
+	return jsonAPIResource{
+		Type:       "users",
+		ID:         strconv.FormatUint(uint64(u.ID), 10),
+		Attributes: attrs,
+	}, nil
+}
+
+// jsonAPI writes data (a User or []User) as a JSON:API document.
+func jsonAPI(c echo.Context, code int, data interface{}) error {
+	doc := jsonAPIDocument{}
+	switch v := data.(type) {
+	case User:
+		res, err := userResource(v)
+		if err != nil {
+			return err
+		}
+		doc.Data = res
+	case []User:
+		list := make([]jsonAPIResource, 0, len(v))
+		for _, u := range v {
+			res, err := userResource(u)
+			if err != nil {
+				return err
+			}
+			list = append(list, res)
+		}
+		doc.Data = list
+	}
+
+	var out interface{} = doc
+	if cfg.JSONNaming == "camel" {
+		v, err := camelCaseKeys(doc)
+		if err != nil {
+			return err
+		}
+		out = v
+	}
+	var b []byte
+	var err error
+	if _, pretty := c.QueryParams()["pretty"]; pretty || cfg.JSONPretty {
+		b, err = json.MarshalIndent(out, "", "  ")
+	} else {
+		b, err = json.Marshal(out)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Blob(code, MIMEApplicationJSONAPI, b)
+}
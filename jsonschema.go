@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// jsonSchema builds a JSON Schema (draft 2020-12) for the struct v from its
+// json, validate and example tags, so it always matches the server's
+// validation rules.
+func jsonSchema(v interface{}) map[string]interface{} {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	properties := map[string]interface{}{}
+	required := []string{}
+	for n := 0; n < t.NumField(); n++ {
+		f := t.Field(n)
+		if !f.IsExported() || f.Tag.Get("json") == "-" {
+			continue
+		}
+		name := jsonFieldName(f)
+		prop := map[string]interface{}{"type": jsonSchemaType(f.Type)}
+
+		for _, rule := range strings.Split(f.Tag.Get("validate"), ",") {
+			rule, param, _ := strings.Cut(rule, "=")
+			switch rule {
+			case "required":
+				required = append(required, name)
+				if prop["type"] == "string" {
+					prop["minLength"] = 1
+				}
+			case "email":
+				prop["format"] = "email"
+			case "max":
+				if max, err := strconv.Atoi(param); err == nil {
+					prop["maxLength"] = max
+				}
+			}
+		}
+		if ex := f.Tag.Get("example"); ex != "" {
+			prop["examples"] = []string{ex}
+		}
+		properties[name] = prop
+	}
+
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                t.Name(),
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func jsonSchemaType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
+
+// @Summary User JSON Schema
+// @Description JSON Schema describing the body accepted by POST /users, generated from the same rules the server validates with
+// @Tags users
+// @Produce application/schema+json
+// @Success 200 {object} map[string]interface{}
+// @Router /users/schema [get]
+func getUserSchema(c echo.Context) error {
+	b, err := json.Marshal(jsonSchema(UserCreateRequest{}))
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/schema+json", b)
+}
@@ -6,133 +6,572 @@ package main
 // @host localhost:8080
 // @BasePath /
 
+// @securityDefinitions.apikey AdminKey
+// @in header
+// @name Authorization
+// @description Admin API key as "Bearer <ADMIN_API_KEY>"
+
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	_ "github.com/CRUD-Golang/docs"
+	"github.com/CRUD-Golang/docs"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	echoSwagger "github.com/swaggo/echo-swagger"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
 )
 
 // User represents the model for a user
 // @Description User model
 type User struct {
-	ID        uint       `json:"id" gorm:"primaryKey"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at,omitempty" gorm:"index"`
-	Name      string     `json:"name"`
-	Email     string     `json:"email"`
-}
-
-// HTTPError represents an error that occurred while handling a request.
-type HTTPError struct {
-	Code    int    `json:"code" example:"400"`
-	Message string `json:"message" example:"status bad request"`
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index" swaggertype:"string" format:"date-time"`
+	Name      string         `json:"name" gorm:"size:255;not null"`
+	Email     string         `json:"email" gorm:"size:255;not null"`
+	Status    string         `json:"status" gorm:"size:32;not null;default:active" example:"active"`
+	// TenantID is only set when MULTI_TENANT is enabled
+	TenantID string `json:"tenant_id,omitempty" gorm:"size:64;not null;default:'';index" example:"acme"`
+	// Emails is only filled in by GET /user/{id}
+	Emails []EmailAddress `json:"emails,omitempty" gorm:"-"`
 }
 
 // UserCreateRequest represents the request body for creating a user
 type UserCreateRequest struct {
+	Name  string `json:"name" example:"Tonkhab" validate:"required,max=255"`
+	Email string `json:"email" example:"Tonkhab@gmail.com" validate:"required,email,max=255"`
+}
+
+// UserUpdateRequest represents the request body for updating a user. Only
+// name and email can be changed; empty fields are left as they are.
+type UserUpdateRequest struct {
 	Name  string `json:"name" example:"Tonkhab"`
 	Email string `json:"email" example:"Tonkhab@gmail.com"`
 }
 
+// LenientCreateResponse is returned by createUser when lenient=true
+type LenientCreateResponse struct {
+	User     *User        `json:"user"`
+	Warnings []FieldError `json:"warnings"`
+}
+
+// DryRunResponse is returned by createUser when dry_run=true and the user
+// could have been created
+type DryRunResponse struct {
+	Valid    bool         `json:"valid" example:"true"`
+	Message  string       `json:"message" example:"User would be created"`
+	Warnings []FieldError `json:"warnings"`
+}
+
+// errDryRun rolls back a transaction that was only run to check it succeeds
+var errDryRun = errors.New("dry run")
+
+// lenientFields are the fields whose validation failures are reported as
+// warnings instead of errors when a request opts into lenient mode.
+var lenientFields = map[string]bool{"name": true}
+
+// hardRules are enforced even in lenient mode: a missing required field or a
+// value too long for its column cannot be stored.
+var hardRules = map[string]bool{"required": true, "max": true}
+
 var db *gorm.DB
 
 func initDB() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
-	}
-
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
+		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
+
+	// Log queries slower than the threshold (and errors) through the app logger
+	dbLogger := logger.New(log.Default(), logger.Config{
+		SlowThreshold:             cfg.DBSlowQueryThreshold,
+		LogLevel:                  logger.Warn,
+		IgnoreRecordNotFoundError: true,
+	})
 
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	var err error
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: dbLogger})
 	if err != nil {
 		log.Fatalf("Failed to connect database: %v", err)
 	}
 
-	// Auto Migration
-	err = db.AutoMigrate(&User{})
+	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
+		log.Fatalf("Failed to get database handle: %v", err)
+	}
+	sqlDB.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	sqlDB.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	if cfg.DBWarmup {
+		if err := warmupPool(context.Background(), sqlDB, cfg.DBWarmupConns); err != nil {
+			log.Fatalf("Failed to warm up database connections: %v", err)
+		}
+	}
+
+	breaker := &circuitBreaker{threshold: cfg.DBBreakerThreshold, cooldown: cfg.DBBreakerCooldown}
+	if err := db.Use(breaker); err != nil {
+		log.Fatalf("Failed to install circuit breaker: %v", err)
+	}
+	if cfg.DBQueryTimeout > 0 {
+		if err := db.Use(queryTimeout{timeout: cfg.DBQueryTimeout}); err != nil {
+			log.Fatalf("Failed to install query timeout: %v", err)
+		}
+	}
+	if cfg.ServerTiming {
+		if err := db.Use(queryTiming{}); err != nil {
+			log.Fatalf("Failed to install query timing: %v", err)
+		}
 	}
+	if cfg.MultiTenant {
+		if err := db.Use(tenantScope{}); err != nil {
+			log.Fatalf("Failed to install tenant scoping: %v", err)
+		}
+	}
+	// Without soft deletes every query is unscoped, so deletes are permanent
+	// and deleted_at is ignored
+	if !cfg.SoftDeleteEnabled {
+		db = db.Unscoped().Session(&gorm.Session{})
+	}
+	NewGaugeFunc("db_circuit_breaker_state", "Database circuit breaker state (0 closed, 1 half-open, 2 open).",
+		func() float64 { return float64(breaker.currentState()) })
+
+	// Auto Migration
+	if cfg.AutoMigrate {
+		if err := migrate(); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
+	checkSchemaDrift()
+	ready.Store(true)
 }
 
 func main() {
+	// .env is optional; settings may come from the real environment instead
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+	loadConfig()
 	initDB()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go refreshUserCount(ctx, cfg.UserCountRefreshInterval)
+	go runWebhookDispatcher(ctx)
+	go runExportWorker(ctx)
+
 	e := echo.New()
-	e.Use(middleware.Logger())
+	for _, s := range []*http.Server{e.Server, e.TLSServer} {
+		s.ReadTimeout = cfg.ReadTimeout
+		s.WriteTimeout = cfg.WriteTimeout
+		s.IdleTimeout = cfg.IdleTimeout
+	}
+	// Rate limiting and access logs key on c.RealIP()
+	e.IPExtractor = ipExtractor()
+	e.Use(accessLog(os.Stdout, cfg.LogSampleRate))
 	e.Use(middleware.Recover())
+	e.Use(instrumentRequests)
+	// Health checks must keep answering while the server sheds load
+	e.Use(limitConcurrency(cfg.MaxConcurrentRequests, "/healthz"))
+	// Never on by default, and refused outright in production
+	if cfg.ChaosEnabled {
+		e.Use(injectChaos(cfg.ChaosDelay, cfg.ChaosDelayRate, cfg.ChaosErrorRate, cfg.ChaosRoutes))
+	}
+	if cfg.ServerTiming {
+		e.Use(serverTiming)
+	}
+	if cfg.RequestLogSize > 0 {
+		requestLog = newRequestLogBuffer(cfg.RequestLogSize)
+		e.Use(recordRequests)
+	}
+	e.Use(allowedHosts(cfg.AllowedHosts))
+	e.Use(requireClientID(cfg.ClientIDHeader, cfg.AllowedClientIDs))
+	if cfg.MultiTenant {
+		e.Use(resolveTenant(cfg.TenantHeader,
+			"/healthz", "/metrics", "/swagger/*", "/openapi.json", "/openapi.yaml"))
+	}
+	e.Use(decompressRequests(cfg.MaxDecompressedBody))
+	// After decompression, so gzip and plain copies of a body match
+	if cfg.DedupWindow > 0 {
+		e.Use(dedupWrites(cfg.DedupWindow, cfg.ClientIDHeader, cfg.TenantHeader))
+	}
+	e.Validator = structValidator{}
+	e.HTTPErrorHandler = errorHandler(e)
+	e.JSONSerializer = jsonSerializer{}
+
+	// Point "Try it out" at the actual deployment when configured
+	if cfg.SwaggerHost != "" {
+		docs.SwaggerInfo.Host = cfg.SwaggerHost
+	}
+	if len(cfg.SwaggerSchemes) > 0 {
+		docs.SwaggerInfo.Schemes = cfg.SwaggerSchemes
+	}
+
+	// OPTIONS requests are answered by echo's router with 204 and an Allow
+	// header built from the methods registered below, so no handler is needed.
+	e.GET("/swagger/*", echoSwagger.EchoWrapHandler(),
+		middleware.Gzip(), swaggerCacheControl(cfg.SwaggerCacheMaxAge))
+	e.GET("/openapi.json", openAPIJSON)
+	e.GET("/openapi.yaml", openAPIYAML)
+	e.GET("/healthz", healthz)
+	e.GET("/metrics", metricsHandler)
+	e.GET("/stats/created-today", getCreatedToday)
+	// Heavy queries share a stricter per-IP budget
+	expensive := rateLimit(rate.Limit(cfg.ExpensiveRatePerSecond), cfg.ExpensiveRateBurst)
 
-	e.GET("/swagger/*", echoSwagger.EchoWrapHandler())
 	e.GET("/users", getUsers)
+	e.GET("/users/stats", getUserStats, expensive)
+	e.GET("/users/timeline", getUserTimeline, expensive)
+	e.GET("/users/count", getUserCount)
+	e.GET("/users/recent", getRecentUsers)
+	e.GET("/users/batch", getUsersBatch)
+	e.GET("/users/export", exportUsers, expensive)
+	e.GET("/users/schema", getUserSchema)
+	e.GET("/users/duplicates", getDuplicateUsers, expensive)
+	// Both email lookups share one budget to slow down enumeration
+	emailLookup := perMinuteRateLimit(cfg.EmailCheckRatePerMinute)
+	e.GET("/users/email-available", checkEmailAvailable, emailLookup)
+	e.GET("/users/by-email", getUserByEmail, emailLookup, adminOnly)
 	e.GET("/user/:id", getUserHandler)
-	e.POST("/users", createUser)
-	e.PUT("/users/:id", updateUser)
+	e.POST("/users", createUser, requireJSON, rejectUnknownFields[UserCreateRequest]())
+	e.POST("/users/bulk", bulkCreateUsers, requireJSON, rejectUnknownFields[BulkCreateRequest]())
+	e.POST("/users/bulk/validate", validateBulkCreate, requireJSON, rejectUnknownFields[BulkCreateRequest]())
+	e.PUT("/users/:id", updateUser, requireJSON)
+	e.PATCH("/users/:id", patchUser)
 	e.DELETE("/users/:id", deleteUser)
-	e.Logger.Fatal(e.Start(":8080"))
+	if cfg.SoftDeleteEnabled {
+		e.POST("/users/bulk-restore", bulkRestoreUsers, requireJSON)
+		e.POST("/users/:id/undo-delete", undoDeleteUser)
+		// Merges soft-delete the source, so they need soft deletes to be
+		// reversible. Still rolling out, so hidden unless FEATURES lists merge
+		featureRoute(e, "merge", http.MethodPost, "/users/:id/merge", mergeUsers, requireJSON)
+		featureRoute(e, "merge", http.MethodPost, "/users/:id/merge/undo", undoMergeUsers)
+	}
+	e.GET("/users/:id/email-history", getEmailHistory, adminOnly)
+	e.POST("/users/:id/emails", addUserEmail, requireJSON)
+	e.DELETE("/users/:id/emails/:email_id", removeUserEmail)
+	e.POST("/users/:id/emails/:email_id/promote", promoteUserEmail)
+	e.POST("/batch", runBatch, requireJSON)
+	e.POST("/exports", createExportJob, expensive)
+	e.GET("/exports/:id", getExportJob)
+	e.GET("/exports/:id/download", downloadExport)
+	e.HEAD("/exports/:id/download", downloadExport)
+
+	admin := e.Group("/admin", adminOnly)
+	// Read-only, so available even when migrations cannot be run over HTTP
+	admin.GET("/migrate/plan", planMigrations)
+	if cfg.AdminMigrateEnabled {
+		admin.POST("/migrate", runMigrations)
+	}
+	if requestLog != nil {
+		admin.GET("/logs", getRequestLogs)
+	}
+	admin.POST("/repair-timestamps", repairTimestamps)
+	admin.GET("/db-stats", getDBStats)
+	admin.GET("/config", getEffectiveConfig)
+	// Never registered outside tests, so production cannot reach it
+	if cfg.Env == "test" {
+		admin.POST("/reset", resetDatabase)
+	}
+	// Off by default: profiles expose internals and cost CPU while running
+	if cfg.EnablePprof {
+		registerPprof(e)
+	}
+
+	go func() {
+		var err error
+		switch {
+		case len(cfg.AutoTLSDomains) > 0:
+			log.Printf("Starting HTTPS server with automatic certificates for %v", cfg.AutoTLSDomains)
+			e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(cfg.AutoTLSDomains...)
+			e.AutoTLSManager.Cache = autocert.DirCache(cfg.AutoTLSCacheDir)
+			err = e.StartAutoTLS(":443")
+		case cfg.TLSCertFile != "":
+			log.Printf("Starting HTTPS server with certificate %s", cfg.TLSCertFile)
+			err = e.StartTLS(":8080", cfg.TLSCertFile, cfg.TLSKeyFile)
+		default:
+			log.Printf("Starting HTTP server")
+			err = e.Start(":8080")
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	// Graceful shutdown on SIGINT/SIGTERM
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		e.Logger.Fatal(err)
+	}
 }
 
 // @Summary Get all users
-// @Description Get all users
+// @Description Get all users. Results can be filtered with filter[field][op]=value params, where field is one of id, name, email, created_at, updated_at and op is one of eq (default), ne, like, gte, lte, in. With Accept: text/csv the page is streamed as CSV, and with Accept: application/x-ndjson as one JSON user per line (both without X-Next-Cursor; paginate with page).
 // @Tags users
-// @Produce json
+// @Produce json,application/vnd.api+json,text/csv,application/x-ndjson
+// @Param page query int false "Page number (1-based)"
+// @Param page_size query int false "Users per page"
+// @Param sort query string false "Column to sort by (defaults to DEFAULT_SORT)"
+// @Param sort_dir query string false "Sort direction (defaults to DEFAULT_SORT_DIR)" Enums(asc, desc)
+// @Param cursor query string false "Opaque X-Next-Cursor value from the previous page (id ordering only)"
+// @Param with_total query bool false "Also count all matching users (costs an extra query)"
 // @Success 200 {array} User
-// @Failure 500 {object} echo.HTTPError
+// @Header 200 {string} X-Next-Cursor "Signed cursor for the next page"
+// @Header 200 {integer} X-Total-Count "Number of users matching the filters, with with_total=true"
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /users [get]
 func getUsers(c echo.Context) error {
+	// The representation depends on Accept (JSON, JSON:API, CSV or NDJSON)
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	p, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	q, err := applyScopes(c, dbFor(c))
+	if err != nil {
+		return err
+	}
+	// Counting costs a second query, so it is opt-in
+	if c.QueryParam("with_total") == "true" {
+		if err := guardScan(q); err != nil {
+			return err
+		}
+		var total int64
+		if err := q.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return dbError(err)
+		}
+		c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	}
+	sort, err := parseSort(c)
+	if err != nil {
+		return err
+	}
+	if q, err = applyCursor(c, q, sort); err != nil {
+		return err
+	}
+
+	// A stable order keeps rows from being skipped or repeated across pages
+	q = q.Order(sort.Clause()).Limit(p.PageSize).Offset(p.Offset())
+	if wantsCSV(c) || wantsNDJSON(c) {
+		rows, err := q.Rows()
+		if err != nil {
+			return dbError(err)
+		}
+		defer rows.Close()
+		if wantsNDJSON(c) {
+			return writeUsersNDJSON(c, rows)
+		}
+		return writeUsersCSV(c, rows, "users.csv")
+	}
+
+	var users []User
+	if err := q.Find(&users).Error; err != nil {
+		return dbError(err)
+	}
+	setNextCursor(c, sort, users, p.PageSize)
+	if wantsJSONAPI(c) {
+		return jsonAPI(c, http.StatusOK, users)
+	}
+	return c.JSON(http.StatusOK, users)
+}
+
+// @Summary Get recently updated users
+// @Description Get users updated after the given time, most recently updated first. Accepts the same filter params as GET /users.
+// @Tags users
+// @Produce json
+// @Param since query string true "Date (YYYY-MM-DD) or RFC 3339 timestamp"
+// @Param limit query int false "Maximum number of users (capped at MAX_PAGE_SIZE)"
+// @Success 200 {array} User
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/recent [get]
+func getRecentUsers(c echo.Context) error {
+	since, err := parseTime("since", c.QueryParam("since"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	limit := cfg.DefaultPageSize
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+	}
+	limit = min(limit, cfg.MaxPageSize)
+
+	q, err := applyScopes(c, dbFor(c))
+	if err != nil {
+		return err
+	}
 	var users []User
-	if err := db.Find(&users).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	err = q.Where("updated_at > ?", since).Order("updated_at DESC, id DESC").Limit(limit).Find(&users).Error
+	if err != nil {
+		return dbError(err)
 	}
 	return c.JSON(http.StatusOK, users)
 }
 
+// BatchUsersResponse holds the users found for a batch lookup and the
+// requested IDs that do not exist
+type BatchUsersResponse struct {
+	Users   []User `json:"users"`
+	Missing []uint `json:"missing"`
+}
+
+// @Summary Get users by IDs
+// @Description Resolve several users in one request. IDs that do not exist are listed in missing, as are those outside the filter params (the same as GET /users).
+// @Tags users
+// @Produce json
+// @Param ids query string true "Comma-separated user IDs (at most MAX_BATCH_IDS)"
+// @Success 200 {object} BatchUsersResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/batch [get]
+func getUsersBatch(c echo.Context) error {
+	var ids []uint
+	seen := map[uint]bool{}
+	for _, part := range strings.Split(c.QueryParam("ids"), ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 0)
+		if err != nil || id == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid user ID %q", part))
+		}
+		if !seen[uint(id)] {
+			seen[uint(id)] = true
+			ids = append(ids, uint(id))
+		}
+	}
+	if len(ids) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "ids is required")
+	}
+	if len(ids) > cfg.MaxBatchIDs {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("At most %d ids per request", cfg.MaxBatchIDs))
+	}
+
+	q, err := applyScopes(c, dbFor(c))
+	if err != nil {
+		return err
+	}
+	users := []User{}
+	if err := q.Order("id").Find(&users, ids).Error; err != nil {
+		return dbError(err)
+	}
+
+	found := map[uint]bool{}
+	for _, u := range users {
+		found[u.ID] = true
+	}
+	missing := []uint{}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return c.JSON(http.StatusOK, BatchUsersResponse{Users: users, Missing: missing})
+}
+
 // @Summary Get user by ID
 // @Description Get user by ID
 // @Tags user
-// @Produce json
+// @Produce json,application/vnd.api+json
 // @Param id path int true "User ID"
+// @Param If-None-Match header string false "ETag from a previous response"
 // @Success 200 {object} User
-// @Failure 404 {object} echo.HTTPError
-// @Failure 500 {object} echo.HTTPError
+// @Header 200 {string} ETag "Version of the user, for If-None-Match and If-Match"
+// @Success 304 "User unchanged"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /user/{id} [get]
 func getUserHandler(c echo.Context) error {
-	id := c.Param("id")
-	var user User
-	if err := db.First(&user, id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "User not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	user, err := lookupUser(c, id)
+	if err != nil {
+		return asHTTPError(err)
+	}
+	etag := userETag(&user)
+	c.Response().Header().Set("ETag", etag)
+	if etagListMatchesWeak(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	if wantsJSONAPI(c) {
+		return jsonAPI(c, http.StatusOK, user)
 	}
 	return c.JSON(http.StatusOK, user)
 }
 
+// userLookups coalesces concurrent getUserHandler queries for the same ID
+var userLookups singleflight.Group
+
+// lookupUser loads a user, sharing one query among concurrent callers asking
+// for the same ID. The query must not be cancelled when just the first
+// caller goes away, so it runs detached from that request (DB_QUERY_TIMEOUT
+// still applies).
+func lookupUser(c echo.Context, id uint) (User, error) {
+	ctx := context.WithoutCancel(c.Request().Context())
+	q, err := scopeUsers(c, db.WithContext(ctx))
+	if err != nil {
+		return User{}, err
+	}
+	// Only callers of the same tenant may share a result
+	tenant, _ := tenantFrom(ctx)
+	key := tenant + "/" + strconv.FormatUint(uint64(id), 10)
+	v, err, _ := userLookups.Do(key, func() (interface{}, error) {
+		var user User
+		if err := q.First(&user, id).Error; err != nil {
+			return user, err
+		}
+		var err error
+		user.Emails, err = userEmails(db.WithContext(ctx), &user)
+		return user, err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return v.(User), nil
+}
+
 // @Summary Create user
-// @Description Create a new user
+// @Description Create a new user. With lenient=true, failures on optional fields are returned as warnings alongside the created user (see LenientCreateResponse).
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param user body UserCreateRequest true "User data"
+// @Param lenient query bool false "Downgrade optional field failures to warnings"
+// @Param dry_run query bool false "Validate and check uniqueness without creating the user"
 // @Success 201 {object} User
-// @Failure 400 {object} HTTPError
-// @Failure 500 {object} HTTPError
+// @Header 201 {string} Location "URL of the created user"
+// @Success 200 {object} DryRunResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /users [post]
 func createUser(c echo.Context) error {
 	req := new(UserCreateRequest)
@@ -140,56 +579,303 @@ func createUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	user := &User{
-		Name:  req.Name,
-		Email: req.Email,
+	lenient := c.QueryParam("lenient") == "true"
+	var warnings []FieldError
+	if err := c.Validate(req); err != nil {
+		verrs, ok := err.(ValidationErrors)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		var hard ValidationErrors
+		for _, fe := range verrs {
+			if lenient && lenientFields[fe.Field] && !hardRules[fe.Rule] {
+				warnings = append(warnings, fe)
+			} else {
+				hard = append(hard, fe)
+			}
+		}
+		if len(hard) > 0 {
+			return validationError(hard)
+		}
+	}
+
+	var user *User
+	dryRun := c.QueryParam("dry_run") == "true"
+	err := writeTx(c, func(tx *gorm.DB) error {
+		user = &User{
+			Name:  req.Name,
+			Email: normalizeEmail(req.Email),
+		}
+		if err := insertUser(tx, user); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if dryRun && errors.Is(err, errDryRun) {
+		if warnings == nil {
+			warnings = []FieldError{}
+		}
+		return c.JSON(http.StatusOK, DryRunResponse{Valid: true, Message: "User would be created", Warnings: warnings})
+	}
+	if err != nil {
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he
+		}
+		return dbError(err)
 	}
+	usersCreatedTotal.Inc()
+	usersCreatedToday.Inc()
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/user/%d", user.ID))
 
-	if err := db.Create(user).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	if lenient {
+		if warnings == nil {
+			warnings = []FieldError{}
+		}
+		return c.JSON(http.StatusCreated, LenientCreateResponse{User: user, Warnings: warnings})
 	}
 	return c.JSON(http.StatusCreated, user)
 }
 
+// insertUser creates user inside tx after checking its email is free, and
+// queues the user.created webhook in the same transaction.
+func insertUser(tx *gorm.DB, user *User) error {
+	taken, err := emailTaken(tx, user.Email)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return newCodedError(http.StatusConflict, CodeEmailTaken, "Email already in use")
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	if err := seedPrimaryEmail(tx, user); err != nil {
+		return err
+	}
+	return enqueueWebhook(tx, "user.created", user)
+}
+
+func validationError(errs ValidationErrors) *echo.HTTPError {
+	return newCodedError(http.StatusBadRequest, CodeValidationFailed, map[string]interface{}{
+		"message": "Validation failed",
+		"errors":  errs,
+	})
+}
+
+// dbFor returns the database handle bound to the request context, so queries
+// are cancelled along with the request.
+func dbFor(c echo.Context) *gorm.DB {
+	return db.WithContext(c.Request().Context())
+}
+
+// dbError converts a database error into the HTTP error returned to clients.
+func dbError(err error) *echo.HTTPError {
+	if errors.Is(err, errCircuitOpen) {
+		return newCodedError(http.StatusServiceUnavailable, CodeDatabaseUnavailable, "Database temporarily unavailable")
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newCodedError(http.StatusGatewayTimeout, CodeDatabaseTimeout, "Database query timed out")
+	}
+	if isTxConflict(err) {
+		return newCodedError(http.StatusConflict, CodeConcurrentUpdate, "Conflicting concurrent update; retry the request")
+	}
+	if errors.Is(err, errTenantRequired) {
+		return newCodedError(http.StatusBadRequest, CodeTenantRequired, "Missing tenant ID header")
+	}
+	if isEmailConflict(err) {
+		return newCodedError(http.StatusConflict, CodeEmailTaken, "Email already in use")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// normalizeEmail trims and lowercases an email so lookups and uniqueness
+// checks are case-insensitive.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EmailAvailableResponse reports whether an email can be used for a new user
+type EmailAvailableResponse struct {
+	Available bool `json:"available" example:"true"`
+}
+
+// @Summary Check email availability
+// @Description Check whether an email is free to register. Rate limited per client IP.
+// @Tags users
+// @Produce json
+// @Param email query string true "Email to check"
+// @Success 200 {object} EmailAvailableResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/email-available [get]
+func checkEmailAvailable(c echo.Context) error {
+	email := normalizeEmail(c.QueryParam("email"))
+	if !isValidEmail(email) {
+		return newCodedError(http.StatusBadRequest, CodeValidationFailed, "email must be a valid email address")
+	}
+
+	taken, err := emailTaken(dbFor(c), email)
+	if err != nil {
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, EmailAvailableResponse{Available: !taken})
+}
+
+func emailTaken(q *gorm.DB, email string) (bool, error) {
+	taken, err := takenEmails(q, []string{email})
+	return taken[email], err
+}
+
+// takenEmails returns which of the given normalized emails are already used
+// by a user, as a primary or secondary address.
+func takenEmails(q *gorm.DB, emails []string) (map[string]bool, error) {
+	taken := map[string]bool{}
+	var found []string
+	if err := q.Model(&User{}).Where("LOWER(email) IN ?", emails).Pluck("LOWER(email)", &found).Error; err != nil {
+		return nil, err
+	}
+	// Secondary addresses of live users are taken too
+	sq := q.Session(&gorm.Session{NewDB: true}).Model(&EmailAddress{}).
+		Joins("JOIN users ON users.id = email_addresses.user_id AND users.deleted_at IS NULL").
+		Where("NOT email_addresses.is_primary AND LOWER(email_addresses.email) IN ?", emails)
+	// Email addresses carry no tenant of their own; scope through the join
+	if tenant, ok := tenantFrom(q.Statement.Context); ok {
+		sq = sq.Where("users.tenant_id = ?", tenant)
+	}
+	var secondary []string
+	if err := sq.Pluck("LOWER(email_addresses.email)", &secondary).Error; err != nil {
+		return nil, err
+	}
+	for _, e := range append(found, secondary...) {
+		taken[e] = true
+	}
+	return taken, nil
+}
+
 // @Summary Update user
-// @Description Update user
+// @Description Update a user's name and email. Other fields (status, tenant, timestamps) cannot be changed through PUT and are ignored.
 // @Tags user
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
-// @Param user body User true "User data"
+// @Param user body UserUpdateRequest true "User data"
+// @Param If-Match header string false "ETag the user must still have"
 // @Success 200 {object} User
-// @Failure 400 {object} echo.HTTPError
-// @Failure 404 {object} echo.HTTPError
-// @Failure 500 {object} echo.HTTPError
+// @Header 200 {string} ETag "ETag of the updated user"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /users/{id} [put]
 func updateUser(c echo.Context) error {
-	id := c.Param("id")
-	user := new(User)
-	if err := c.Bind(user); err != nil {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	// Never bind User itself: that would let clients set tenant_id, status
+	// or deleted_at
+	req := new(UserUpdateRequest)
+	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
-	if err := db.Model(&User{}).Where("id = ?", id).Updates(user).Error; err != nil {
+	var user User
+	err = writeTx(c, func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, &user); err != nil {
+			return err
+		}
+		// Same validation, email normalization and uniqueness checks as PATCH
+		updates := map[string]interface{}{}
+		if req.Name != "" {
+			updates["name"] = req.Name
+		}
+		if req.Email != "" {
+			updates["email"] = req.Email
+		}
+		if err := applyUserUpdates(tx, id, &user, updates); err != nil {
+			return err
+		}
+		// Reload so the body and ETag show updated_at as stored
+		return tx.First(&user, id).Error
+	})
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "User not found")
+			return newCodedError(http.StatusNotFound, CodeUserNotFound, "User not found")
+		}
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return dbError(err)
 	}
+	c.Response().Header().Set("ETag", userETag(&user))
 	return c.JSON(http.StatusOK, user)
 }
 
 // @Summary Delete user
-// @Description Delete user
+// @Description Soft-delete user; it can be brought back with POST /users/bulk-restore. With return=true the deleted user record is returned instead of a message.
 // @Tags user
 // @Produce json
 // @Param id path int true "User ID"
+// @Param return query bool false "Return the deleted user"
 // @Success 200 {string} string "User deleted successfully"
-// @Failure 500 {object} echo.HTTPError
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /users/{id} [delete]
 func deleteUser(c echo.Context) error {
-	id := c.Param("id")
-	if err := db.Delete(&User{}, id).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	var user User
+	err = writeTx(c, func(tx *gorm.DB) error {
+		return deleteUserTx(tx, &user, id)
+	})
+	if c.QueryParam("return") == "true" {
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return newCodedError(http.StatusNotFound, CodeUserNotFound, "User not found")
+			}
+			return dbError(err)
+		}
+		return c.JSON(http.StatusOK, user)
+	}
+	// Deleting a user that does not exist is not an error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("User with ID %d deleted", id)})
+}
+
+// deleteUserTx soft-deletes the user with the given id inside tx, loading
+// it into user, and queues the user.deleted webhook in the same transaction.
+func deleteUserTx(tx *gorm.DB, user *User, id uint) error {
+	// Lock the row so the recorded user is exactly what gets deleted
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(user, id).Error; err != nil {
+		return err
+	}
+	if err := tx.Delete(user).Error; err != nil {
+		return err
+	}
+	return enqueueWebhook(tx, "user.deleted", user)
+}
+
+// parseID reads the :id path parameter, rejecting anything that is not a
+// positive integer before it reaches the database.
+func parseID(c echo.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil || id == 0 {
+		return 0, newCodedError(http.StatusBadRequest, CodeInvalidID, "Invalid user ID")
 	}
-	return c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("User with ID %s deleted", id)})
+	return uint(id), nil
 }
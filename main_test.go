@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Tests that need PostgreSQL run against the database named by TEST_DB_NAME
+// on the server given by the usual DB_* settings, and are skipped when it is
+// unset. They wipe its tables, so never point it at real data:
+//
+//	docker compose up -d db
+//	DB_HOST=localhost DB_USER=postgres DB_PASSWORD=postgresql TEST_DB_NAME=users_test go test ./...
+func TestMain(m *testing.M) {
+	if name := os.Getenv("TEST_DB_NAME"); name != "" {
+		os.Setenv("DB_NAME", name)
+		os.Setenv("ENV", "test")
+		// Only requests that name a tenant are scoped, so this is harmless
+		// for tests that do not
+		os.Setenv("MULTI_TENANT", "true")
+		loadConfig()
+		initDB()
+	}
+	os.Exit(m.Run())
+}
+
+// requireDB skips t unless a test database is configured, and empties it.
+func requireDB(t *testing.T) {
+	t.Helper()
+	if db == nil {
+		t.Skip("TEST_DB_NAME not set")
+	}
+	for _, model := range migrationModels {
+		table, err := tableName(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Exec("DELETE FROM " + table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serve runs a GET request for target through e, with the given header
+// name/value pairs.
+func serve(e *echo.Echo, target string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserMerge records one user being merged into another. It is the audit
+// trail of merges and holds what POST /users/{id}/merge/undo needs to put
+// both users back.
+type UserMerge struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	TargetID  uint      `json:"target_id" gorm:"not null;index" example:"1"`
+	SourceID  uint      `json:"source_id" gorm:"not null;index" example:"2"`
+	// The target's name and email before the merge
+	TargetName  string `json:"target_name" gorm:"size:255;not null"`
+	TargetEmail string `json:"target_email" gorm:"size:255;not null"`
+	// The primary address rows of both users before the merge, and the
+	// source's addresses that were moved to the target
+	TargetPrimaryID uint       `json:"-"`
+	SourcePrimaryID uint       `json:"-"`
+	MovedEmailIDs   []uint     `json:"moved_email_ids" gorm:"type:text;serializer:json"`
+	UndoneAt        *time.Time `json:"undone_at,omitempty"`
+}
+
+// MergeUsersRequest is the body of POST /users/{id}/merge. Email and Name
+// pick whose value the merged user keeps when both users have one; a value
+// only one of them has is always kept.
+type MergeUsersRequest struct {
+	FromID uint   `json:"from_id" example:"2"`
+	Email  string `json:"email,omitempty" example:"target" enums:"target,source"`
+	Name   string `json:"name,omitempty" example:"target" enums:"target,source"`
+}
+
+// MergeResponse is the merged (or, after an undo, restored) target user and
+// the merge record
+type MergeResponse struct {
+	User  User      `json:"user"`
+	Merge UserMerge `json:"merge"`
+}
+
+// primaryAddress returns user's primary address row, storing it first for
+// users created before addresses were stored.
+func primaryAddress(tx *gorm.DB, user *User) (EmailAddress, error) {
+	var address EmailAddress
+	err := tx.Where("user_id = ? AND is_primary", user.ID).First(&address).Error
+	if err == gorm.ErrRecordNotFound {
+		address = EmailAddress{UserID: user.ID, Email: user.Email, Primary: true}
+		err = tx.Create(&address).Error
+	}
+	return address, err
+}
+
+// setPrimaryAddress makes the address row primaryID the only primary one of
+// userID.
+func setPrimaryAddress(tx *gorm.DB, userID, primaryID uint) error {
+	return tx.Model(&EmailAddress{}).Where("user_id = ?", userID).
+		Update("is_primary", gorm.Expr("id = ?", primaryID)).Error
+}
+
+// @Summary Merge two users
+// @Description Merge the user from_id into the user id in one transaction. The source's email addresses move to the target (except ones the target already has), fields the target lacks are copied from the source, and the source is soft-deleted. When both users have an email or name, email and name choose which one is kept (target by default); the other email stays on the target as a secondary address. The merge is recorded and can be undone within UNDO_DELETE_WINDOW. Sends user.updated for the target and user.deleted for the source. Only available when SOFT_DELETE_ENABLED is on and FEATURES includes merge.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param id path int true "Target user ID"
+// @Param request body MergeUsersRequest true "User to merge and conflict choices"
+// @Param If-Match header string false "ETag the target must still have"
+// @Success 200 {object} MergeResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/merge [post]
+func mergeUsers(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	req := new(MergeUsersRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	switch {
+	case req.FromID == 0:
+		return echo.NewHTTPError(http.StatusBadRequest, "from_id is required")
+	case req.FromID == id:
+		return echo.NewHTTPError(http.StatusBadRequest, "A user cannot be merged into itself")
+	case req.Email != "" && req.Email != "target" && req.Email != "source":
+		return echo.NewHTTPError(http.StatusBadRequest, "email must be target or source")
+	case req.Name != "" && req.Name != "target" && req.Name != "source":
+		return echo.NewHTTPError(http.StatusBadRequest, "name must be target or source")
+	}
+
+	var target User
+	var merge UserMerge
+	err = writeTx(c, func(tx *gorm.DB) error {
+		// Lock both rows in ID order so concurrent merges cannot deadlock
+		var users []User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", []uint{id, req.FromID}).Order("id").Find(&users).Error
+		if err != nil {
+			return err
+		}
+		if len(users) != 2 {
+			return gorm.ErrRecordNotFound
+		}
+		var source User
+		if target, source = users[0], users[1]; target.ID != id {
+			target, source = source, target
+		}
+		if err := checkIfMatch(c, &target); err != nil {
+			return err
+		}
+		before := target
+
+		targetPrimary, err := primaryAddress(tx, &target)
+		if err != nil {
+			return err
+		}
+		sourcePrimary, err := primaryAddress(tx, &source)
+		if err != nil {
+			return err
+		}
+		merge = UserMerge{
+			TargetID: target.ID, SourceID: source.ID,
+			TargetName: target.Name, TargetEmail: target.Email,
+			TargetPrimaryID: targetPrimary.ID, SourcePrimaryID: sourcePrimary.ID,
+			MovedEmailIDs: []uint{},
+		}
+
+		// Move the source's addresses the target does not have yet
+		var targetAddresses, sourceAddresses []EmailAddress
+		if err := tx.Where("user_id = ?", target.ID).Find(&targetAddresses).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", source.ID).Order("id").Find(&sourceAddresses).Error; err != nil {
+			return err
+		}
+		have := map[string]bool{}
+		for _, a := range targetAddresses {
+			have[strings.ToLower(a.Email)] = true
+		}
+		for _, a := range sourceAddresses {
+			if !have[strings.ToLower(a.Email)] {
+				merge.MovedEmailIDs = append(merge.MovedEmailIDs, a.ID)
+				have[strings.ToLower(a.Email)] = true
+			}
+		}
+		if len(merge.MovedEmailIDs) > 0 {
+			err := tx.Model(&EmailAddress{}).Where("id IN ?", merge.MovedEmailIDs).
+				Updates(map[string]interface{}{"user_id": target.ID, "is_primary": false}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// The source goes first so its email is free for the target
+		if err := tx.Delete(&source).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"updated_at": time.Now()}
+		if source.Name != "" && (target.Name == "" || req.Name == "source") {
+			updates["name"] = source.Name
+		}
+		if req.Email == "source" && !strings.EqualFold(source.Email, target.Email) {
+			var primary EmailAddress
+			err := tx.Where("user_id = ? AND LOWER(email) = ?", target.ID, strings.ToLower(source.Email)).
+				First(&primary).Error
+			if err != nil {
+				return err
+			}
+			if err := setPrimaryAddress(tx, target.ID, primary.ID); err != nil {
+				return err
+			}
+			if err := recordEmailChange(tx, &target, source.Email); err != nil {
+				return err
+			}
+			updates["email"] = source.Email
+		}
+		if err := tx.Model(&target).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&merge).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&target, id).Error; err != nil {
+			return err
+		}
+		if err := enqueueWebhook(tx, "user.deleted", source); err != nil {
+			return err
+		}
+		return enqueueWebhook(tx, "user.updated", userChange{Before: before, After: target})
+	})
+	if err != nil {
+		return mergeError(err)
+	}
+	if target.Emails, err = userEmails(dbFor(c), &target); err != nil {
+		return dbError(err)
+	}
+
+	log.Printf("Merged user %d into %d (merge %d)", merge.SourceID, merge.TargetID, merge.ID)
+	c.Response().Header().Set("ETag", userETag(&target))
+	return c.JSON(http.StatusOK, MergeResponse{User: target, Merge: merge})
+}
+
+// @Summary Undo a merge
+// @Description Reverse the latest merge into the user within UNDO_DELETE_WINDOW: the source is restored with its email addresses, and the target gets back its name, email and addresses from before the merge (discarding changes made to them since). Sends user.updated for both users. Returns 410 once the window has passed. Only available when SOFT_DELETE_ENABLED is on and FEATURES includes merge.
+// @Tags user
+// @Produce json
+// @Param id path int true "Target user ID"
+// @Success 200 {object} MergeResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/merge/undo [post]
+func undoMergeUsers(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	var target User
+	var merge UserMerge
+	err = writeTx(c, func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&target, id).Error; err != nil {
+			return err
+		}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("target_id = ? AND undone_at IS NULL", id).Order("id DESC").First(&merge).Error
+		if err == gorm.ErrRecordNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "No merge to undo")
+		}
+		if err != nil {
+			return err
+		}
+		if time.Since(merge.CreatedAt) > cfg.UndoDeleteWindow {
+			return newCodedError(http.StatusGone, CodeUndoWindowExpired, "Undo window has passed")
+		}
+		var source User
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&source, merge.SourceID).Error; err != nil {
+			return err
+		}
+		if !source.DeletedAt.Valid {
+			return newCodedError(http.StatusConflict, CodeUserNotDeleted, "User is not deleted")
+		}
+		before, sourceBefore := target, source
+
+		// Give the target back its own name, email and addresses first, so
+		// the source's email is free again when the source is restored
+		if len(merge.MovedEmailIDs) > 0 {
+			err := tx.Model(&EmailAddress{}).Where("id IN ? AND user_id = ?", merge.MovedEmailIDs, target.ID).
+				Update("user_id", source.ID).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := setPrimaryAddress(tx, target.ID, merge.TargetPrimaryID); err != nil {
+			return err
+		}
+		if err := setPrimaryAddress(tx, source.ID, merge.SourcePrimaryID); err != nil {
+			return err
+		}
+		if err := recordEmailChange(tx, &target, merge.TargetEmail); err != nil {
+			return err
+		}
+		err = tx.Model(&target).Updates(map[string]interface{}{"name": merge.TargetName, "email": merge.TargetEmail}).Error
+		if err != nil {
+			return err
+		}
+
+		taken, err := emailTaken(tx, normalizeEmail(source.Email))
+		if err != nil {
+			return err
+		}
+		if taken {
+			return newCodedError(http.StatusConflict, CodeEmailTaken, "Email already in use")
+		}
+		if err := tx.Unscoped().Model(&source).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		merge.UndoneAt = &now
+		if err := tx.Model(&merge).Update("undone_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&target, id).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&source, source.ID).Error; err != nil {
+			return err
+		}
+		if err := enqueueWebhook(tx, "user.updated", userChange{Before: sourceBefore, After: source}); err != nil {
+			return err
+		}
+		return enqueueWebhook(tx, "user.updated", userChange{Before: before, After: target})
+	})
+	if err != nil {
+		return mergeError(err)
+	}
+	if target.Emails, err = userEmails(dbFor(c), &target); err != nil {
+		return dbError(err)
+	}
+
+	log.Printf("Undid merge %d of user %d into %d", merge.ID, merge.SourceID, merge.TargetID)
+	c.Response().Header().Set("ETag", userETag(&target))
+	return c.JSON(http.StatusOK, MergeResponse{User: target, Merge: merge})
+}
+
+// mergeError maps errors of the merge transactions to responses
+func mergeError(err error) error {
+	if err == gorm.ErrRecordNotFound {
+		return newCodedError(http.StatusNotFound, CodeUserNotFound, "User not found")
+	}
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he
+	}
+	return dbError(err)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// collector is a metric that can render itself in the Prometheus text
+// exposition format.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	metricsMu  sync.Mutex
+	collectors = map[string]collector{}
+)
+
+// register adds c to the set exposed at /metrics. Registering a name twice
+// returns the existing collector so repeated setup never panics.
+func register(c collector) collector {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if existing, ok := collectors[c.name()]; ok {
+		return existing
+	}
+	collectors[c.name()] = c
+	return c
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	Name  string
+	Help  string
+	value atomic.Uint64
+}
+
+// NewCounter creates and registers a counter.
+func NewCounter(name, help string) *Counter {
+	return register(&Counter{Name: name, Help: help}).(*Counter)
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() { c.value.Add(1) }
+
+func (c *Counter) name() string { return c.Name }
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.Name, c.Help, c.Name, c.Name, c.value.Load())
+}
+
+// GaugeFunc reports the value returned by fn at scrape time.
+type GaugeFunc struct {
+	Name string
+	Help string
+	fn   func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge backed by fn.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return register(&GaugeFunc{Name: name, Help: help, fn: fn}).(*GaugeFunc)
+}
+
+func (g *GaugeFunc) name() string { return g.Name }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.Name, g.Help, g.Name, g.Name, g.fn())
+}
+
+// CounterFunc reports the monotonically increasing value returned by fn at
+// scrape time.
+type CounterFunc struct {
+	Name string
+	Help string
+	fn   func() float64
+}
+
+// NewCounterFunc creates and registers a counter backed by fn.
+func NewCounterFunc(name, help string, fn func() float64) *CounterFunc {
+	return register(&CounterFunc{Name: name, Help: help, fn: fn}).(*CounterFunc)
+}
+
+func (c *CounterFunc) name() string { return c.Name }
+
+func (c *CounterFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %g\n", c.Name, c.Help, c.Name, c.Name, c.fn())
+}
+
+// labelEscaper escapes label values for the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders names and values as {a="x",b="y"}, with extra
+// appended as-is (used for the le label of histogram buckets).
+func formatLabels(names, values []string, extra string) string {
+	parts := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		parts = append(parts, n+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// seriesKey joins label values into a map key.
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	Name   string
+	Help   string
+	Labels []string
+
+	mu     sync.Mutex
+	series map[string]*labeledValue[uint64]
+}
+
+type labeledValue[T any] struct {
+	values []string
+	value  T
+}
+
+// NewCounterVec creates and registers a counter with the given labels.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return register(&CounterVec{Name: name, Help: help, Labels: labels, series: map[string]*labeledValue[uint64]{}}).(*CounterVec)
+}
+
+// Inc increments the counter for the given label values, in Labels order.
+func (c *CounterVec) Inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := seriesKey(values)
+	s, ok := c.series[key]
+	if !ok {
+		s = &labeledValue[uint64]{values: values}
+		c.series[key] = s
+	}
+	s.value++
+}
+
+func (c *CounterVec) name() string { return c.Name }
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.Name, c.Help, c.Name)
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %d\n", c.Name, formatLabels(c.Labels, s.values, ""), s.value)
+	}
+}
+
+// defaultBuckets are latency buckets in seconds, as used by Prometheus clients
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogramData struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	Name    string
+	Help    string
+	Labels  []string
+	Buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*labeledValue[*histogramData]
+}
+
+// NewHistogramVec creates and registers a histogram with the given upper
+// bucket bounds and labels.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return register(&HistogramVec{
+		Name: name, Help: help, Labels: labels, Buckets: buckets,
+		series: map[string]*labeledValue[*histogramData]{},
+	}).(*HistogramVec)
+}
+
+// Observe records v for the given label values, in Labels order.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := seriesKey(values)
+	s, ok := h.series[key]
+	if !ok {
+		s = &labeledValue[*histogramData]{values: values, value: &histogramData{counts: make([]uint64, len(h.Buckets))}}
+		h.series[key] = s
+	}
+	for i, bound := range h.Buckets {
+		if v <= bound {
+			s.value.counts[i]++
+			break
+		}
+	}
+	s.value.sum += v
+	s.value.count++
+}
+
+func (h *HistogramVec) name() string { return h.Name }
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.Name, h.Help, h.Name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.Buckets {
+			cumulative += s.value.counts[i]
+			le := `le="` + strconv.FormatFloat(bound, 'g', -1, 64) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, formatLabels(h.Labels, s.values, le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, formatLabels(h.Labels, s.values, `le="+Inf"`), s.value.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.Name, formatLabels(h.Labels, s.values, ""), s.value.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.Name, formatLabels(h.Labels, s.values, ""), s.value.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	httpRequestsTotal = NewCounterVec("http_requests_total",
+		"HTTP requests handled, by method, route and status.", "method", "route", "status")
+	httpRequestDuration = NewHistogramVec("http_request_duration_seconds",
+		"HTTP request latency in seconds, by method and route.", defaultBuckets, "method", "route")
+)
+
+// instrumentRequests records every request in httpRequestsTotal and
+// httpRequestDuration, labeled by the route template (not the raw path) to
+// keep the number of series bounded.
+func instrumentRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			// Write the error now so the final status is known
+			c.Error(err)
+		}
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request().Method
+		httpRequestsTotal.Inc(method, route, strconv.Itoa(c.Response().Status))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), method, route)
+		return err
+	}
+}
+
+var usersCreatedTotal = NewCounter("users_created_total", "Users created since startup.")
+
+// dailyCounter counts events for the current UTC day, resetting at midnight.
+type dailyCounter struct {
+	mu    sync.Mutex
+	day   string
+	count int64
+}
+
+// Inc records one event for today.
+func (d *dailyCounter) Inc() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rollover()
+	d.count++
+}
+
+// Get returns the current day and its count.
+func (d *dailyCounter) Get() (string, int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rollover()
+	return d.day, d.count
+}
+
+func (d *dailyCounter) rollover() {
+	if today := time.Now().UTC().Format(time.DateOnly); today != d.day {
+		d.day = today
+		d.count = 0
+	}
+}
+
+var usersCreatedToday dailyCounter
+
+// CreatedTodayResponse reports how many users this instance created today
+type CreatedTodayResponse struct {
+	Date  string `json:"date" example:"2024-06-01"`
+	Count int64  `json:"count" example:"42"`
+}
+
+// metricsHandler serves all registered collectors in the Prometheus text format.
+func metricsHandler(c echo.Context) error {
+	metricsMu.Lock()
+	names := make([]string, 0, len(collectors))
+	for n := range collectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	list := make([]collector, len(names))
+	for i, n := range names {
+		list[i] = collectors[n]
+	}
+	metricsMu.Unlock()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	for _, col := range list {
+		col.write(res)
+	}
+	return nil
+}
+
+// @Summary Users created today
+// @Description Number of users created by this instance since midnight UTC (resets on restart)
+// @Tags stats
+// @Produce json
+// @Success 200 {object} CreatedTodayResponse
+// @Router /stats/created-today [get]
+func getCreatedToday(c echo.Context) error {
+	day, count := usersCreatedToday.Get()
+	return c.JSON(http.StatusOK, CreatedTodayResponse{Date: day, Count: count})
+}
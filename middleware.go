@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+// ipExtractor returns how c.RealIP() determines the client address. Only
+// X-Forwarded-For hops added by TRUSTED_PROXIES are honoured; without any
+// trusted proxies the headers are ignored so clients cannot spoof their IP.
+func ipExtractor() echo.IPExtractor {
+	if len(cfg.TrustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, ipNet := range cfg.TrustedProxies {
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// perMinuteRateLimit limits each client IP to n requests per minute.
+func perMinuteRateLimit(n int) echo.MiddlewareFunc {
+	return rateLimit(rate.Limit(float64(n)/60), n)
+}
+
+// rateLimitIdle is how long a client's budget is kept after its last request
+const rateLimitIdle = 3 * time.Minute
+
+// rateLimitVisitor is one client's token bucket
+type rateLimitVisitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimit limits each client IP to r requests per second with the given
+// burst. Routes sharing the returned middleware share one budget per IP.
+// Every response reports the client's budget: X-RateLimit-Limit is the
+// burst, X-RateLimit-Remaining the requests left right now and
+// X-RateLimit-Reset the seconds until the budget is full again. Once a
+// tenth of the budget (at least one request) is left a Warning header is
+// added, except with a burst of 1, where every request would carry it; once
+// the budget is exhausted requests get 429 with Retry-After.
+func rateLimit(r rate.Limit, burst int) echo.MiddlewareFunc {
+	var (
+		mu        sync.Mutex
+		visitors  = map[string]*rateLimitVisitor{}
+		lastSweep = time.Now()
+	)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ip, now := c.RealIP(), time.Now()
+			mu.Lock()
+			if now.Sub(lastSweep) > time.Minute {
+				for k, v := range visitors {
+					if now.Sub(v.lastSeen) > rateLimitIdle {
+						delete(visitors, k)
+					}
+				}
+				lastSweep = now
+			}
+			v, ok := visitors[ip]
+			if !ok {
+				v = &rateLimitVisitor{limiter: rate.NewLimiter(r, burst)}
+				visitors[ip] = v
+			}
+			v.lastSeen = now
+			allowed := v.limiter.AllowN(now, 1)
+			tokens := v.limiter.TokensAt(now)
+			mu.Unlock()
+
+			remaining := max(int(math.Floor(tokens)), 0)
+			header := c.Response().Header()
+			header.Set("X-RateLimit-Limit", strconv.Itoa(burst))
+			header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			header.Set("X-RateLimit-Reset", strconv.Itoa(secondsToRefill(r, float64(burst)-tokens)))
+			if !allowed {
+				header.Set("Retry-After", strconv.Itoa(max(secondsToRefill(r, 1-tokens), 1)))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
+			}
+			if burst > 1 && remaining <= max(1, burst/10) {
+				header.Set("Warning", `199 - "Rate limit nearly exhausted"`)
+			}
+			return next(c)
+		}
+	}
+}
+
+// secondsToRefill returns how many whole seconds it takes to add tokens to
+// a bucket refilling at r per second.
+func secondsToRefill(r rate.Limit, tokens float64) int {
+	if tokens <= 0 || r == rate.Inf || r <= 0 {
+		return 0
+	}
+	return int(math.Ceil(tokens / float64(r)))
+}
+
+// requireJSON rejects requests whose Content-Type is not application/json
+// (parameters such as charset are allowed) with 415 Unsupported Media Type.
+func requireJSON(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		mediaType, _, err := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+		if err != nil || mediaType != echo.MIMEApplicationJSON {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		}
+		return next(c)
+	}
+}
+
+// rejectUnknownFields returns middleware that rejects JSON bodies with
+// fields T does not declare, naming the offending field. It is opt-in per
+// route; other decode errors are left for c.Bind to report.
+func rejectUnknownFields[T any]() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(new(T)); err != nil {
+				if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unknown field %s", field))
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// allowedHosts rejects requests whose Host header is not in ALLOWED_HOSTS,
+// protecting generated links from host header poisoning. An entry without a
+// port matches the host on any port; "*" or an empty list disables the check.
+func allowedHosts(hosts []string) echo.MiddlewareFunc {
+	allowed := map[string]bool{}
+	for _, h := range hosts {
+		if h == "*" {
+			return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+		}
+		allowed[strings.ToLower(h)] = true
+	}
+	if len(allowed) == 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			host := strings.ToLower(c.Request().Host)
+			hostname := host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				hostname = h
+			}
+			if !allowed[host] && !allowed[hostname] {
+				return newCodedError(http.StatusBadRequest, CodeInvalidHost, "Invalid Host header")
+			}
+			return next(c)
+		}
+	}
+}
+
+// swaggerCacheControl lets browsers cache the Swagger UI assets for maxAge.
+// doc.json is revalidated on every load since it changes with each deploy.
+func swaggerCacheControl(maxAge time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			value := "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+			if strings.HasSuffix(c.Request().URL.Path, "/doc.json") {
+				value = "no-cache"
+			}
+			c.Response().Header().Set(echo.HeaderCacheControl, value)
+			return next(c)
+		}
+	}
+}
+
+// decompressRequests transparently inflates request bodies sent with
+// Content-Encoding: gzip so c.Bind sees plain JSON. The inflated body is
+// capped at limit bytes (413) to guard against zip bombs; malformed gzip is
+// rejected with 400.
+func decompressRequests(limit int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if !strings.EqualFold(req.Header.Get(echo.HeaderContentEncoding), "gzip") || req.Body == nil {
+				return next(c)
+			}
+			zr, err := gzip.NewReader(req.Body)
+			if err != nil {
+				return newCodedError(http.StatusBadRequest, CodeMalformedBody, "Malformed gzip request body")
+			}
+			defer zr.Close()
+
+			// Read one byte past the limit to tell "exactly limit" from "too large"
+			body, err := io.ReadAll(io.LimitReader(zr, limit+1))
+			if err != nil {
+				return newCodedError(http.StatusBadRequest, CodeMalformedBody, "Malformed gzip request body")
+			}
+			if int64(len(body)) > limit {
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+					fmt.Sprintf("Decompressed request body exceeds %d bytes", limit))
+			}
+
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+			req.Header.Del(echo.HeaderContentEncoding)
+			req.Header.Set(echo.HeaderContentLength, strconv.Itoa(len(body)))
+			return next(c)
+		}
+	}
+}
+
+// inFlightRequests counts requests currently being handled
+var inFlightRequests atomic.Int64
+
+var _ = NewGaugeFunc("http_requests_in_flight", "HTTP requests currently being handled.",
+	func() float64 { return float64(inFlightRequests.Load()) })
+
+// limitConcurrency caps the number of requests handled at once at max,
+// answering any beyond it immediately with 503 and Retry-After instead of
+// queueing them. Paths in bypass are never limited. max <= 0 only counts
+// in-flight requests.
+func limitConcurrency(max int, bypass ...string) echo.MiddlewareFunc {
+	skip := map[string]bool{}
+	for _, p := range bypass {
+		skip[p] = true
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skip[c.Request().URL.Path] {
+				return next(c)
+			}
+			if n := inFlightRequests.Add(1); max > 0 && n > int64(max) {
+				inFlightRequests.Add(-1)
+				c.Response().Header().Set("Retry-After", "1")
+				return newCodedError(http.StatusServiceUnavailable, CodeServerBusy, "Server is busy; retry shortly")
+			}
+			defer inFlightRequests.Add(-1)
+			return next(c)
+		}
+	}
+}
+
+// requireClientID rejects write requests (anything but GET, HEAD and
+// OPTIONS) whose header does not name one of the allowed clients, with 403.
+// An empty allowlist disables the check.
+func requireClientID(header string, allowed []string) echo.MiddlewareFunc {
+	if len(allowed) == 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+	clients := map[string]bool{}
+	for _, id := range allowed {
+		clients[id] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			id := c.Request().Header.Get(header)
+			if id == "" {
+				return newCodedError(http.StatusForbidden, CodeClientIDRequired, "Missing client ID header")
+			}
+			if !clients[id] {
+				return newCodedError(http.StatusForbidden, CodeUnknownClient, "Unknown client ID")
+			}
+			return next(c)
+		}
+	}
+}
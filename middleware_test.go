@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitWarnsWhenNearlyExhausted(t *testing.T) {
+	tests := []struct {
+		name  string
+		burst int
+		// want[i] is whether request i+1 carries the Warning header
+		want []bool
+	}{
+		{name: "burst 1", burst: 1, want: []bool{false}},
+		{name: "burst 10", burst: 10, want: []bool{false, false, false, false, false, false, false, false, true, true}},
+		{name: "burst 30", burst: 30, want: append(make([]bool, 26), true, true, true, true)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			// Slow enough that no token is refilled during the test
+			e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) },
+				rateLimit(rate.Limit(0.001), tt.burst))
+
+			for i, want := range tt.want {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("request %d: status %d", i+1, rec.Code)
+				}
+				if got := rec.Header().Get("Warning") != ""; got != want {
+					t.Errorf("request %d (remaining %s): warning = %v, want %v",
+						i+1, rec.Header().Get("X-RateLimit-Remaining"), got, want)
+				}
+			}
+		})
+	}
+}
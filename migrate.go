@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// migrationModels lists every model managed by AutoMigrate
+var migrationModels = []interface{}{&User{}, &WebhookEvent{}, &UserEmail{}, &ExportJob{}, &EmailAddress{}, &UserMerge{}}
+
+// MigrationResult describes what a migration run changed
+type MigrationResult struct {
+	TablesCreated []string            `json:"tables_created"`
+	ColumnsAdded  map[string][]string `json:"columns_added"`
+}
+
+// usersEmailIndex enforces case-insensitive email uniqueness among live
+// users of each tenant in the database itself, backing up the emailTaken
+// check against races and direct writes. GORM tags cannot express an index
+// on lower(email), so migrate creates it after AutoMigrate.
+const usersEmailIndex = "idx_users_tenant_email_lower"
+
+// isEmailConflict reports whether err is a unique violation (23505) of
+// usersEmailIndex, i.e. another live user already has the email.
+func isEmailConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == usersEmailIndex
+}
+
+func migrate() error {
+	if err := db.AutoMigrate(migrationModels...); err != nil {
+		return err
+	}
+	// Superseded by usersEmailIndex when emails became unique per tenant
+	if err := db.Exec("DROP INDEX IF EXISTS idx_users_email_lower").Error; err != nil {
+		return err
+	}
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + usersEmailIndex +
+		" ON users (tenant_id, lower(email)) WHERE deleted_at IS NULL").Error
+}
+
+// tableColumns returns the existing columns of every model's table, keyed by
+// table name. Missing tables are left out.
+func tableColumns() (map[string]map[string]bool, error) {
+	tables := map[string]map[string]bool{}
+	for _, model := range migrationModels {
+		table, err := tableName(model)
+		if err != nil {
+			return nil, err
+		}
+		if !db.Migrator().HasTable(table) {
+			continue
+		}
+		types, err := db.Migrator().ColumnTypes(table)
+		if err != nil {
+			return nil, err
+		}
+		cols := map[string]bool{}
+		for _, t := range types {
+			cols[t.Name()] = true
+		}
+		tables[table] = cols
+	}
+	return tables, nil
+}
+
+func tableName(model interface{}) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return "", err
+	}
+	return stmt.Schema.Table, nil
+}
+
+// SchemaDrift lists the tables, columns and indexes the models expect but
+// the database lacks
+type SchemaDrift struct {
+	MissingTables  []string            `json:"missing_tables,omitempty"`
+	MissingColumns map[string][]string `json:"missing_columns,omitempty"`
+	MissingIndexes map[string][]string `json:"missing_indexes,omitempty"`
+}
+
+// HasDrift reports whether anything is missing.
+func (d SchemaDrift) HasDrift() bool {
+	return len(d.MissingTables) > 0 || len(d.MissingColumns) > 0 || len(d.MissingIndexes) > 0
+}
+
+// schemaDrift compares every migration model against the live schema.
+func schemaDrift() (SchemaDrift, error) {
+	drift := SchemaDrift{MissingColumns: map[string][]string{}, MissingIndexes: map[string][]string{}}
+	m := db.Migrator()
+	for _, model := range migrationModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return drift, err
+		}
+		table := stmt.Schema.Table
+		if !m.HasTable(table) {
+			drift.MissingTables = append(drift.MissingTables, table)
+			continue
+		}
+		for _, f := range stmt.Schema.Fields {
+			if f.DBName != "" && !m.HasColumn(model, f.DBName) {
+				drift.MissingColumns[table] = append(drift.MissingColumns[table], f.DBName)
+			}
+		}
+		for name := range stmt.Schema.ParseIndexes() {
+			if !m.HasIndex(model, name) {
+				drift.MissingIndexes[table] = append(drift.MissingIndexes[table], name)
+			}
+		}
+	}
+	if m.HasTable(&User{}) && !m.HasIndex(&User{}, usersEmailIndex) {
+		drift.MissingIndexes["users"] = append(drift.MissingIndexes["users"], usersEmailIndex)
+	}
+	if len(drift.MissingColumns) == 0 {
+		drift.MissingColumns = nil
+	}
+	if len(drift.MissingIndexes) == 0 {
+		drift.MissingIndexes = nil
+	}
+	return drift, nil
+}
+
+// checkSchemaDrift logs any drift between the models and the database at
+// startup, or aborts when SCHEMA_DRIFT_STRICT is set.
+func checkSchemaDrift() {
+	drift, err := schemaDrift()
+	if err != nil {
+		log.Printf("Warning: could not check schema drift: %v", err)
+		return
+	}
+	if !drift.HasDrift() {
+		return
+	}
+	report := fmt.Sprintf("tables %v, columns %v, indexes %v",
+		drift.MissingTables, drift.MissingColumns, drift.MissingIndexes)
+	if cfg.SchemaDriftStrict {
+		log.Fatalf("Schema drift detected (run the migrations): missing %s", report)
+	}
+	log.Printf("Warning: schema drift detected (run the migrations): missing %s", report)
+}
+
+// MigrationPlan lists what POST /admin/migrate would add
+type MigrationPlan struct {
+	Pending bool `json:"pending" example:"true"`
+	SchemaDrift
+}
+
+// @Summary Preview database migrations
+// @Description Report the tables, columns and indexes POST /admin/migrate would create, without changing anything. Changes to existing columns (such as new size limits or NOT NULL) are not detected. Requires the admin API key.
+// @Tags admin
+// @Produce json
+// @Security AdminKey
+// @Success 200 {object} MigrationPlan
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/migrate/plan [get]
+func planMigrations(c echo.Context) error {
+	drift, err := schemaDrift()
+	if err != nil {
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, MigrationPlan{Pending: drift.HasDrift(), SchemaDrift: drift})
+}
+
+// @Summary Run database migrations
+// @Description Run AutoMigrate for all models and report the tables and columns it added. Requires ADMIN_MIGRATE_ENABLED and the admin API key.
+// @Tags admin
+// @Produce json
+// @Security AdminKey
+// @Success 200 {object} MigrationResult
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/migrate [post]
+func runMigrations(c echo.Context) error {
+	before, err := tableColumns()
+	if err != nil {
+		return dbError(err)
+	}
+	if err := migrate(); err != nil {
+		return dbError(err)
+	}
+	after, err := tableColumns()
+	if err != nil {
+		return dbError(err)
+	}
+
+	result := MigrationResult{TablesCreated: []string{}, ColumnsAdded: map[string][]string{}}
+	for table, cols := range after {
+		existing, ok := before[table]
+		if !ok {
+			result.TablesCreated = append(result.TablesCreated, table)
+			continue
+		}
+		for col := range cols {
+			if !existing[col] {
+				result.ColumnsAdded[table] = append(result.ColumnsAdded[table], col)
+			}
+		}
+	}
+
+	log.Printf("Admin migration from %s: tables created %v, columns added %v",
+		c.RealIP(), result.TablesCreated, result.ColumnsAdded)
+	return c.JSON(http.StatusOK, result)
+}
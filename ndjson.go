@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MIMEApplicationNDJSON is the newline-delimited JSON media type
+const MIMEApplicationNDJSON = "application/x-ndjson"
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON
+// in Accept.
+func wantsNDJSON(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), MIMEApplicationNDJSON)
+}
+
+// writeUsersNDJSON streams the users in rows one JSON object per line,
+// flushing every csvFlushEvery rows. Keys follow JSON_NAMING like every
+// other response; JSON_PRETTY does not apply.
+func writeUsersNDJSON(c echo.Context, rows *sql.Rows) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+	extendWriteDeadline(c)
+	res.WriteHeader(http.StatusOK)
+
+	w := bufio.NewWriter(res)
+	enc := json.NewEncoder(w)
+	flush := func() error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		res.Flush()
+		extendWriteDeadline(c)
+		return nil
+	}
+	for n := 1; rows.Next(); n++ {
+		var u User
+		if err := db.ScanRows(rows, &u); err != nil {
+			return err
+		}
+		var v interface{} = u
+		if cfg.JSONNaming == "camel" {
+			var err error
+			if v, err = camelCaseKeys(u); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		if n%csvFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
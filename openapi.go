@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/CRUD-Golang/docs"
+	"github.com/ghodss/yaml"
+	"github.com/labstack/echo/v4"
+)
+
+// openAPIJSON serves the generated spec, with the host and schemes
+// overrides applied, for CI contract tests and client generators.
+func openAPIJSON(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, []byte(docs.SwaggerInfo.ReadDoc()))
+}
+
+// openAPIYAML serves the same spec converted to YAML.
+func openAPIYAML(c echo.Context) error {
+	spec, err := yaml.JSONToYAML([]byte(docs.SwaggerInfo.ReadDoc()))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.Blob(http.StatusOK, "application/yaml", spec)
+}
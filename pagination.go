@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Pagination is the page window requested by a list endpoint
+type Pagination struct {
+	Page     int
+	PageSize int
+}
+
+// Offset returns the number of rows to skip for the requested page.
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// parsePagination reads page and page_size from the query string, applying
+// the configured default and maximum page size.
+func parsePagination(c echo.Context) (Pagination, error) {
+	p := Pagination{Page: 1, PageSize: cfg.DefaultPageSize}
+
+	if v := c.QueryParam("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return p, echo.NewHTTPError(http.StatusBadRequest, "page must be a positive integer")
+		}
+		p.Page = page
+	}
+
+	if v := c.QueryParam("page_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			return p, echo.NewHTTPError(http.StatusBadRequest, "page_size must be a positive integer")
+		}
+		p.PageSize = size
+	}
+
+	if p.PageSize > cfg.MaxPageSize {
+		if cfg.RejectOversizedPages {
+			return p, echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("page_size must not exceed %d", cfg.MaxPageSize))
+		}
+		p.PageSize = cfg.MaxPageSize
+	}
+	return p, nil
+}
+
+// Sort is the ordering requested by a list endpoint
+type Sort struct {
+	Column string
+	Dir    string
+}
+
+// Clause returns the ORDER BY clause, appending id as a tiebreaker so the
+// order is always deterministic.
+func (s Sort) Clause() string {
+	order := s.Column + " " + s.Dir
+	if s.Column != "id" {
+		order += ", id " + s.Dir
+	}
+	return order
+}
+
+// parseSort reads the sort and sort_dir params, falling back to
+// DEFAULT_SORT/DEFAULT_SORT_DIR. Any column that accepts filters can be
+// sorted on.
+func parseSort(c echo.Context) (Sort, error) {
+	s := Sort{Column: cfg.DefaultSort, Dir: cfg.DefaultSortDir}
+	if v := c.QueryParam("sort"); v != "" {
+		if _, ok := filterableColumns[v]; !ok {
+			return s, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Cannot sort by %q", v))
+		}
+		s.Column = v
+	}
+	if v := c.QueryParam("sort_dir"); v != "" {
+		if v != "asc" && v != "desc" {
+			return s, echo.NewHTTPError(http.StatusBadRequest, "sort_dir must be asc or desc")
+		}
+		s.Dir = v
+	}
+	return s, nil
+}
+
+// guardScan rejects a request that reads every user q matches at once when
+// Postgres estimates there are more than MAX_SCAN_ROWS of them, so narrow
+// filters stay allowed however large the table grows. Without an estimate
+// the request is let through.
+func guardScan(q *gorm.DB) error {
+	if cfg.MaxScanRows == 0 {
+		return nil
+	}
+	estimate, ok, err := estimateRows(q)
+	if err != nil {
+		return dbError(err)
+	}
+	if ok && estimate > int64(cfg.MaxScanRows) {
+		return newCodedError(http.StatusBadRequest, CodeQueryTooExpensive,
+			"Query would scan too many rows; narrow the filters or paginate instead")
+	}
+	return nil
+}
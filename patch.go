@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Media types accepted by PATCH /users/:id
+const (
+	// MIMEApplicationMergePatch is the RFC 7386 JSON Merge Patch media type
+	MIMEApplicationMergePatch = "application/merge-patch+json"
+	// MIMEApplicationJSONPatch is the RFC 6902 JSON Patch media type
+	MIMEApplicationJSONPatch = "application/json-patch+json"
+)
+
+// patchableFields maps the fields a patch may change to the value they are
+// reset to when cleared with null. Fields mapped to nil cannot be cleared;
+// like POST /users, a user always keeps a name and an email.
+var patchableFields = map[string]interface{}{
+	"name":   nil,
+	"email":  nil,
+	"status": "active",
+}
+
+// readOnlyFields are User fields a patch can never change
+var readOnlyFields = map[string]bool{
+	"id":         true,
+	"created_at": true,
+	"updated_at": true,
+	"deleted_at": true,
+}
+
+// JSONPatchOperation is one RFC 6902 operation
+type JSONPatchOperation struct {
+	Op    string          `json:"op" example:"replace" enums:"add,replace,remove"`
+	Path  string          `json:"path" example:"/email"`
+	Value json.RawMessage `json:"value,omitempty" swaggertype:"string" example:"new@gmail.com"`
+}
+
+// fieldMaxLength mirrors the column sizes declared on User
+var fieldMaxLength = map[string]int{
+	"name":   255,
+	"email":  255,
+	"status": 32,
+}
+
+// @Summary Patch user
+// @Description Partially update a user with an RFC 7386 JSON Merge Patch (application/merge-patch+json or application/json): absent fields are left unchanged, null clears a field and any other value sets it. Alternatively send an RFC 6902 JSON Patch array (application/json-patch+json) using add, replace or remove on /name, /email or /status. Only name, email and status can be patched; name and email cannot be cleared or set empty.
+// @Tags user
+// @Accept application/merge-patch+json,application/json-patch+json,json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param patch body object true "Merge patch document or JSON Patch array"
+// @Param If-Match header string false "ETag the user must still have"
+// @Success 200 {object} User
+// @Header 200 {string} ETag "ETag of the updated user"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id} [patch]
+func patchUser(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	var updates map[string]interface{}
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	switch mediaType {
+	case MIMEApplicationMergePatch, echo.MIMEApplicationJSON:
+		var patch map[string]json.RawMessage
+		if err := json.NewDecoder(c.Request().Body).Decode(&patch); err != nil {
+			return newCodedError(http.StatusBadRequest, CodeMalformedBody, "Body must be a JSON object")
+		}
+		updates, err = mergePatchUpdates(patch)
+	case MIMEApplicationJSONPatch:
+		var ops []JSONPatchOperation
+		if err := json.NewDecoder(c.Request().Body).Decode(&ops); err != nil {
+			return newCodedError(http.StatusBadRequest, CodeMalformedBody, "Body must be a JSON array of patch operations")
+		}
+		updates, err = jsonPatchUpdates(ops)
+	default:
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType,
+			"Content-Type must be "+MIMEApplicationMergePatch+", "+MIMEApplicationJSONPatch+" or "+echo.MIMEApplicationJSON)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var user User
+	err = writeTx(c, func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, &user); err != nil {
+			return err
+		}
+		if err := applyUserUpdates(tx, id, &user, updates); err != nil {
+			return err
+		}
+		// Reload so the ETag uses updated_at as stored
+		return tx.First(&user, id).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return newCodedError(http.StatusNotFound, CodeUserNotFound, "User not found")
+		}
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he
+		}
+		return dbError(err)
+	}
+	c.Response().Header().Set("ETag", userETag(&user))
+	return c.JSON(http.StatusOK, user)
+}
+
+// jsonPatchUpdates translates RFC 6902 operations into a GORM update map.
+// Users are flat, so every path names a single field: add and replace set
+// it, remove resets it like null in a merge patch. Later operations on the
+// same field win, as if applied in order.
+func jsonPatchUpdates(ops []JSONPatchOperation) (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+	for i, op := range ops {
+		field, ok := strings.CutPrefix(op.Path, "/")
+		if !ok || strings.Contains(field, "/") {
+			return nil, fmt.Errorf("operation %d: path %q must name a single field", i, op.Path)
+		}
+		field = strings.NewReplacer("~1", "/", "~0", "~").Replace(field)
+		if readOnlyFields[field] {
+			return nil, fmt.Errorf("operation %d: field %s is read-only", i, field)
+		}
+		reset, ok := patchableFields[field]
+		if !ok {
+			return nil, fmt.Errorf("operation %d: field %s cannot be patched", i, field)
+		}
+
+		switch op.Op {
+		case "add", "replace":
+			var v string
+			if err := json.Unmarshal(op.Value, &v); err != nil {
+				return nil, fmt.Errorf("operation %d: value for %s must be a string", i, field)
+			}
+			updates[field] = v
+		case "remove":
+			if reset == nil {
+				return nil, fmt.Errorf("operation %d: field %s cannot be removed", i, field)
+			}
+			updates[field] = reset
+		default:
+			return nil, fmt.Errorf("operation %d: unsupported op %q (use add, replace or remove)", i, op.Op)
+		}
+	}
+	return updates, nil
+}
+
+// mergePatchUpdates translates a merge patch into a GORM update map,
+// distinguishing null (reset the field) from a value (set it).
+func mergePatchUpdates(patch map[string]json.RawMessage) (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+	for field, raw := range patch {
+		reset, ok := patchableFields[field]
+		if !ok {
+			return nil, fmt.Errorf("field %s cannot be patched", field)
+		}
+
+		if string(raw) == "null" {
+			if reset == nil {
+				return nil, fmt.Errorf("field %s cannot be cleared", field)
+			}
+			updates[field] = reset
+			continue
+		}
+
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("field %s must be a string", field)
+		}
+		updates[field] = v
+	}
+	return updates, nil
+}
+
+// applyUserUpdates validates and applies a field update map to the user with
+// the given id inside tx, loading the updated record into user.
+func applyUserUpdates(tx *gorm.DB, id uint, user *User, updates map[string]interface{}) error {
+	if err := tx.First(user, id).Error; err != nil {
+		return err
+	}
+	before := *user
+
+	for field, v := range updates {
+		s, _ := v.(string)
+		if max := fieldMaxLength[field]; len([]rune(s)) > max {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be at most %d characters", field, max))
+		}
+	}
+	// Required on create, so no update may empty it either
+	if name, ok := updates["name"].(string); ok && name == "" {
+		return newCodedError(http.StatusBadRequest, CodeValidationFailed, "name is required")
+	}
+	if email, ok := updates["email"].(string); ok {
+		email = normalizeEmail(email)
+		if !isValidEmail(email) {
+			return newCodedError(http.StatusBadRequest, CodeValidationFailed, "email must be a valid email address")
+		}
+		taken, err := emailTaken(tx.Where("id <> ?", id), email)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return newCodedError(http.StatusConflict, CodeEmailTaken, "Email already in use")
+		}
+		if err := recordEmailChange(tx, user, email); err != nil {
+			return err
+		}
+		if err := syncPrimaryEmail(tx, id, email); err != nil {
+			return err
+		}
+		updates["email"] = email
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+	if err := tx.Model(user).Updates(updates).Error; err != nil {
+		return err
+	}
+	return enqueueWebhook(tx, "user.updated", userChange{Before: before, After: *user})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// registerPprof mounts the net/http/pprof handlers under /debug/pprof behind
+// adminOnly. Named profiles such as heap and goroutine are served by
+// pprof.Index, which reads the name from the path.
+func registerPprof(e *echo.Echo) {
+	g := e.Group("/debug/pprof", adminOnly)
+	g.GET("/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+	g.GET("/profile", timedProfile(pprof.Profile, 30))
+	g.GET("/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	g.POST("/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	g.GET("/trace", timedProfile(pprof.Trace, 1))
+	g.GET("/*", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+}
+
+// timedProfile wraps a pprof handler that records for ?seconds=
+// (defaultSeconds without it), extending the write deadline by that long.
+// Older net/http/pprof versions reject any duration beyond the server's
+// WriteTimeout with 400, which would break the default 30s CPU profile, so
+// the server is hidden from the handler.
+func timedProfile(h http.HandlerFunc, defaultSeconds float64) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		seconds, err := strconv.ParseFloat(c.QueryParam("seconds"), 64)
+		if err != nil || seconds <= 0 {
+			seconds = defaultSeconds
+		}
+		if cfg.WriteTimeout > 0 {
+			record := time.Duration(seconds * float64(time.Second))
+			http.NewResponseController(c.Response()).SetWriteDeadline(time.Now().Add(record + cfg.WriteTimeout))
+		}
+		req := c.Request()
+		h(c.Response(), req.WithContext(context.WithValue(req.Context(), http.ServerContextKey, nil)))
+		return nil
+	}
+}
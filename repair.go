@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// repairBatchSize is how many users POST /admin/repair-timestamps fixes per
+// transaction
+const repairBatchSize = 500
+
+// brokenTimestamp returns a condition matching column when it is NULL or
+// holds Go's zero time, as written by the bugs this repairs.
+func brokenTimestamp(column string) string {
+	return fmt.Sprintf("(%[1]s IS NULL OR %[1]s <= '0001-01-01 00:00:00+00')", column)
+}
+
+var (
+	brokenCreatedAt = brokenTimestamp("created_at")
+	brokenUpdatedAt = brokenTimestamp("updated_at")
+)
+
+// RepairResult reports how many users POST /admin/repair-timestamps fixed
+// (or, with dry_run, would fix)
+type RepairResult struct {
+	CreatedAtFixed int64 `json:"created_at_fixed" example:"12"`
+	UpdatedAtFixed int64 `json:"updated_at_fixed" example:"40"`
+	Batches        int   `json:"batches" example:"1"`
+	DryRun         bool  `json:"dry_run,omitempty"`
+}
+
+// @Summary Repair missing user timestamps
+// @Description Backfill NULL or zero created_at and updated_at on all users, soft-deleted ones included. created_at is copied from the nearest earlier user by ID with a valid created_at (or the nearest later one, or now); updated_at is set to now. Rows are fixed in batches, each in its own transaction, so the repair can be run again safely after a failure. With dry_run=true only the counts are reported. Requires the admin API key.
+// @Tags admin
+// @Produce json
+// @Security AdminKey
+// @Param dry_run query bool false "Count the broken rows without changing them"
+// @Success 200 {object} RepairResult
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/repair-timestamps [post]
+func repairTimestamps(c echo.Context) error {
+	result := RepairResult{DryRun: c.QueryParam("dry_run") == "true"}
+	if result.DryRun {
+		err := dbFor(c).Raw("SELECT COUNT(*) FILTER (WHERE "+brokenCreatedAt+"), COUNT(*) FILTER (WHERE "+brokenUpdatedAt+") FROM users").
+			Row().Scan(&result.CreatedAtFixed, &result.UpdatedAtFixed)
+		if err != nil {
+			return dbError(err)
+		}
+		return c.JSON(http.StatusOK, result)
+	}
+
+	// Fixed rows no longer match, but walking by ID guarantees progress even
+	// if a row cannot be fixed
+	var lastID uint
+	for {
+		var ids []uint
+		err := dbFor(c).Raw("SELECT id FROM users WHERE id > ? AND ("+brokenCreatedAt+" OR "+brokenUpdatedAt+") ORDER BY id LIMIT ?",
+			lastID, repairBatchSize).Scan(&ids).Error
+		if err != nil {
+			return dbError(err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		var created, updated int64
+		err = writeTx(c, func(tx *gorm.DB) error {
+			res := tx.Exec(`UPDATE users u SET created_at = COALESCE(
+					(SELECT p.created_at FROM users p WHERE p.id < u.id AND NOT `+brokenTimestamp("p.created_at")+` ORDER BY p.id DESC LIMIT 1),
+					(SELECT n.created_at FROM users n WHERE n.id > u.id AND NOT `+brokenTimestamp("n.created_at")+` ORDER BY n.id LIMIT 1),
+					?)
+				WHERE u.id IN ? AND `+brokenTimestamp("u.created_at"), time.Now(), ids)
+			if res.Error != nil {
+				return res.Error
+			}
+			created = res.RowsAffected
+			res = tx.Exec("UPDATE users SET updated_at = ? WHERE id IN ? AND "+brokenUpdatedAt, time.Now(), ids)
+			updated = res.RowsAffected
+			return res.Error
+		})
+		if err != nil {
+			return dbError(err)
+		}
+		result.CreatedAtFixed += created
+		result.UpdatedAtFixed += updated
+		result.Batches++
+		lastID = ids[len(ids)-1]
+	}
+
+	log.Printf("Timestamp repair from %s: created_at fixed %d, updated_at fixed %d",
+		c.RealIP(), result.CreatedAtFixed, result.UpdatedAtFixed)
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// redactedParams are query params whose values never reach the request log
+var redactedParams = []string{"email", "password", "token", "key", "secret", "cursor"}
+
+// RequestLogEntry is one request kept for GET /admin/logs
+type RequestLogEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method" example:"GET"`
+	URI       string    `json:"uri" example:"/users?email=REDACTED"`
+	Status    int       `json:"status" example:"200"`
+	LatencyMS float64   `json:"latency_ms" example:"3.2"`
+	RemoteIP  string    `json:"remote_ip" example:"203.0.113.7"`
+	ClientID  string    `json:"client_id,omitempty" example:"billing-service"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// requestLogBuffer is a fixed-size ring of the most recent requests
+type requestLogBuffer struct {
+	mu      sync.Mutex
+	entries []RequestLogEntry
+	next    int
+	full    bool
+}
+
+func newRequestLogBuffer(size int) *requestLogBuffer {
+	return &requestLogBuffer{entries: make([]RequestLogEntry, size)}
+}
+
+func (b *requestLogBuffer) add(e RequestLogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// recent returns up to limit entries, newest first.
+func (b *requestLogBuffer) recent(limit int) []RequestLogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.next
+	if b.full {
+		n = len(b.entries)
+	}
+	limit = min(limit, n)
+	out := make([]RequestLogEntry, 0, limit)
+	for i := 1; i <= limit; i++ {
+		out = append(out, b.entries[(b.next-i+len(b.entries))%len(b.entries)])
+	}
+	return out
+}
+
+// requestLog is nil when REQUEST_LOG_SIZE is 0
+var requestLog *requestLogBuffer
+
+// redactURI replaces the values of sensitive query params.
+func redactURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	q := u.Query()
+	for key := range q {
+		for _, name := range redactedParams {
+			if strings.Contains(strings.ToLower(key), name) {
+				q.Set(key, "REDACTED")
+				break
+			}
+		}
+	}
+	return u.Path + "?" + q.Encode()
+}
+
+// recordRequests pushes every request into requestLog. Errors are handled
+// here so the entry has the final status; they are still returned for the
+// access log, and the error handler skips the already written response.
+func recordRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		entry := RequestLogEntry{
+			Time:     start,
+			Method:   c.Request().Method,
+			URI:      redactURI(c.Request().URL),
+			RemoteIP: c.RealIP(),
+			ClientID: c.Request().Header.Get(cfg.ClientIDHeader),
+		}
+		if err != nil {
+			entry.Error = err.Error()
+			c.Error(err)
+		}
+		entry.Status = c.Response().Status
+		entry.LatencyMS = float64(time.Since(start)) / float64(time.Millisecond)
+		requestLog.add(entry)
+		return err
+	}
+}
+
+// @Summary Tail recent requests
+// @Description List the most recent requests handled by this instance, newest first. Kept in memory only (REQUEST_LOG_SIZE entries) and lost on restart; sensitive query params are redacted. Requires the admin API key.
+// @Tags admin
+// @Produce json
+// @Security AdminKey
+// @Param limit query int false "Maximum number of entries (default 100)"
+// @Success 200 {array} RequestLogEntry
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/logs [get]
+func getRequestLogs(c echo.Context) error {
+	limit := 100
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+		limit = n
+	}
+	return c.JSON(http.StatusOK, requestLog.recent(limit))
+}
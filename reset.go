@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxResetSeed caps how many users POST /admin/reset?seed= creates
+const maxResetSeed = 1000
+
+// ResetResult reports what POST /admin/reset removed and created
+type ResetResult struct {
+	Deleted map[string]int64 `json:"deleted"`
+	Seeded  int              `json:"seeded"`
+}
+
+// @Summary Reset the database
+// @Description Truncate every table managed by the app, including soft-deleted rows and in multi-tenant mode the rows of every tenant, and optionally seed fresh users (for the requesting tenant). Only registered when ENV=test; requires the admin API key.
+// @Tags admin
+// @Produce json
+// @Security AdminKey
+// @Param seed query int false "Number of users to create after truncating"
+// @Success 200 {object} ResetResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/reset [post]
+func resetDatabase(c echo.Context) error {
+	// The route is only registered for ENV=test; check again in case that changes
+	if cfg.Env != "test" {
+		return echo.ErrNotFound
+	}
+
+	seed := 0
+	if v := c.QueryParam("seed"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxResetSeed {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("seed must be between 0 and %d", maxResetSeed))
+		}
+		seed = n
+	}
+
+	result := ResetResult{Deleted: map[string]int64{}, Seeded: seed}
+	err := writeTx(c, func(tx *gorm.DB) error {
+		var tables []string
+		for _, model := range migrationModels {
+			table, err := tableName(model)
+			if err != nil {
+				return err
+			}
+			// Raw SQL escapes tenantScope, so this counts every tenant's rows
+			// like the TRUNCATE below removes them
+			var count int64
+			if err := tx.Raw("SELECT COUNT(*) FROM " + table).Scan(&count).Error; err != nil {
+				return err
+			}
+			result.Deleted[table] = count
+			tables = append(tables, table)
+		}
+		if err := tx.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY").Error; err != nil {
+			return err
+		}
+
+		if seed == 0 {
+			return nil
+		}
+		users := make([]User, seed)
+		for i := range users {
+			users[i] = User{Name: fmt.Sprintf("Test User %d", i+1), Email: fmt.Sprintf("user%d@example.com", i+1)}
+		}
+		return tx.Create(&users).Error
+	})
+	if err != nil {
+		return dbError(err)
+	}
+
+	log.Printf("Admin reset from %s: deleted %v, seeded %d users", c.RealIP(), result.Deleted, seed)
+	return c.JSON(http.StatusOK, result)
+}
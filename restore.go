@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// BulkRestoreRequest lists the soft-deleted users to restore
+type BulkRestoreRequest struct {
+	IDs []uint `json:"ids" example:"1,2,3"`
+}
+
+// BulkRestoreResponse reports the outcome for every requested ID
+type BulkRestoreResponse struct {
+	Restored    int64  `json:"restored" example:"2"`
+	RestoredIDs []uint `json:"restored_ids"`
+	// Skipped IDs belong to users that are not deleted
+	Skipped []uint `json:"skipped"`
+	// Conflicts are deleted users whose email is now used by another user,
+	// or by a user listed before them in the same request
+	Conflicts []uint `json:"conflicts"`
+	Missing   []uint `json:"missing"`
+}
+
+// @Summary Restore soft-deleted users
+// @Description Restore several soft-deleted users in one transaction. Users that are not deleted are skipped, and users whose email has since been taken, or is shared with a user listed earlier in the request, are reported as conflicts.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body BulkRestoreRequest true "IDs to restore"
+// @Success 200 {object} BulkRestoreResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/bulk-restore [post]
+func bulkRestoreUsers(c echo.Context) error {
+	req := new(BulkRestoreRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(req.IDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "ids is required")
+	}
+	if len(req.IDs) > cfg.MaxBatchIDs {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("At most %d ids per request", cfg.MaxBatchIDs))
+	}
+
+	var res BulkRestoreResponse
+	err := writeTx(c, func(tx *gorm.DB) error {
+		res = BulkRestoreResponse{RestoredIDs: []uint{}, Skipped: []uint{}, Conflicts: []uint{}, Missing: []uint{}}
+		var users []User
+		if err := tx.Unscoped().Find(&users, req.IDs).Error; err != nil {
+			return err
+		}
+		byID := map[uint]User{}
+		for _, u := range users {
+			byID[u.ID] = u
+		}
+
+		// Emails of the users restored so far, so two deleted users sharing
+		// an email do not both come back and fail on the unique index
+		seen := map[string]bool{}
+		seenIDs := map[uint]bool{}
+		for _, id := range req.IDs {
+			if seenIDs[id] {
+				continue
+			}
+			seenIDs[id] = true
+			u, ok := byID[id]
+			switch {
+			case !ok:
+				res.Missing = append(res.Missing, id)
+			case !u.DeletedAt.Valid:
+				res.Skipped = append(res.Skipped, id)
+			default:
+				email := normalizeEmail(u.Email)
+				taken, err := emailTaken(tx, email)
+				if err != nil {
+					return err
+				}
+				if taken || seen[email] {
+					res.Conflicts = append(res.Conflicts, id)
+				} else {
+					seen[email] = true
+					res.RestoredIDs = append(res.RestoredIDs, id)
+				}
+			}
+		}
+		if len(res.RestoredIDs) == 0 {
+			return nil
+		}
+
+		result := tx.Unscoped().Model(&User{}).
+			Where("id IN ? AND deleted_at IS NOT NULL", res.RestoredIDs).
+			Update("deleted_at", nil)
+		res.Restored = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// @Summary Undo a delete
+// @Description Restore a user deleted within the last UNDO_DELETE_WINDOW. Returns 410 once the window has passed.
+// @Tags user
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} User
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/undo-delete [post]
+func undoDeleteUser(c echo.Context) error {
+	id, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	var user User
+	err = writeTx(c, func(tx *gorm.DB) error {
+		if err := tx.Unscoped().First(&user, id).Error; err != nil {
+			return err
+		}
+		if !user.DeletedAt.Valid {
+			return newCodedError(http.StatusConflict, CodeUserNotDeleted, "User is not deleted")
+		}
+		if time.Since(user.DeletedAt.Time) > cfg.UndoDeleteWindow {
+			return newCodedError(http.StatusGone, CodeUndoWindowExpired, "Undo window has passed")
+		}
+		taken, err := emailTaken(tx, normalizeEmail(user.Email))
+		if err != nil {
+			return err
+		}
+		if taken {
+			return newCodedError(http.StatusConflict, CodeEmailTaken, "Email already in use")
+		}
+		user.DeletedAt = gorm.DeletedAt{}
+		return tx.Unscoped().Model(&user).Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return newCodedError(http.StatusNotFound, CodeUserNotFound, "User not found")
+		}
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he
+		}
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// txMaxAttempts is how often a write transaction is tried when Postgres
+// aborts it with a serialization failure or deadlock
+const txMaxAttempts = 3
+
+// isTxConflict reports whether err is a serialization failure (40001) or a
+// deadlock (40P01), after which the transaction can simply be run again.
+func isTxConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// writeTx runs fn in a transaction, retrying it with a short jittered delay
+// when it loses a serialization conflict or deadlock. fn may run several
+// times, so it must not carry state over from a failed attempt.
+func writeTx(c echo.Context, fn func(tx *gorm.DB) error) error {
+	ctx := c.Request().Context()
+	for attempt := 1; ; attempt++ {
+		err := dbFor(c).Transaction(fn)
+		if err == nil || !isTxConflict(err) || attempt == txMaxAttempts {
+			return err
+		}
+		delay := time.Duration(attempt)*10*time.Millisecond + rand.N(10*time.Millisecond)
+		c.Logger().Debugf("Retrying transaction in %s (attempt %d of %d): %v", delay, attempt+1, txMaxAttempts, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type timingKey struct{}
+
+// requestTiming accumulates the database time spent by one request
+type requestTiming struct {
+	dbTime    atomic.Int64
+	dbQueries atomic.Int64
+}
+
+// serverTiming adds a Server-Timing header splitting each request's latency
+// into database time, the rest of the handler ("app") and the total, as
+// seen by browser devtools. Database time is only recorded for queries run
+// through dbFor(c).
+func serverTiming(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		timing := &requestTiming{}
+		req := c.Request()
+		c.SetRequest(req.WithContext(context.WithValue(req.Context(), timingKey{}, timing)))
+
+		c.Response().Before(func() {
+			total := time.Since(start)
+			dbTime := time.Duration(timing.dbTime.Load())
+			c.Response().Header().Set("Server-Timing", fmt.Sprintf(
+				`db;dur=%.1f;desc="%d queries", app;dur=%.1f, total;dur=%.1f`,
+				ms(dbTime), timing.dbQueries.Load(), ms(total-dbTime), ms(total)))
+		})
+		return next(c)
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// queryTiming is a GORM plugin adding each statement's duration to the
+// requestTiming in its context, if any.
+type queryTiming struct{}
+
+// Name implements gorm.Plugin.
+func (queryTiming) Name() string { return "query_timing" }
+
+// Initialize implements gorm.Plugin.
+func (queryTiming) Initialize(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet("timing:start", time.Now())
+	}
+	after := func(tx *gorm.DB) {
+		timing, ok := tx.Statement.Context.Value(timingKey{}).(*requestTiming)
+		if !ok {
+			return
+		}
+		if start, ok := tx.InstanceGet("timing:start"); ok {
+			timing.dbTime.Add(int64(time.Since(start.(time.Time))))
+			timing.dbQueries.Add(1)
+		}
+	}
+	return registerAround(db, "timing", before, after, true)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// groupableFields whitelists the group_by values accepted by getUserStats,
+// mapped to the SQL expression they group on.
+var groupableFields = map[string]string{
+	"status": "status",
+	"month":  "to_char(date_trunc('month', created_at), 'YYYY-MM')",
+}
+
+// @Summary Count users by group
+// @Description Count users grouped by status or by creation month (YYYY-MM). Accepts the same filter params as GET /users. Rejected with 400 when Postgres estimates more than MAX_SCAN_ROWS users match.
+// @Tags users
+// @Produce json
+// @Param group_by query string true "Field to group by" Enums(status, month)
+// @Success 200 {array} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Router /users/stats [get]
+func getUserStats(c echo.Context) error {
+	groupBy := c.QueryParam("group_by")
+	expr, ok := groupableFields[groupBy]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "group_by must be one of: status, month")
+	}
+
+	q, err := applyScopes(c, dbFor(c))
+	if err != nil {
+		return err
+	}
+	if err := guardScan(q); err != nil {
+		return err
+	}
+
+	results := []map[string]interface{}{}
+	err = q.Select(expr + " AS " + groupBy + ", COUNT(*) AS count").
+		Group(expr).
+		Order(expr).
+		Scan(&results).Error
+	if err != nil {
+		return dbError(err)
+	}
+	return c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultTimelineBuckets is how many buckets are returned without from
+	defaultTimelineBuckets = 30
+	// maxTimelineBuckets caps how many buckets one timeline request may span
+	maxTimelineBuckets = 1000
+)
+
+// timelineIntervals whitelists the interval values accepted by
+// getUserTimeline, mapped to the date_trunc field they bucket on.
+var timelineIntervals = map[string]string{
+	"day":   "day",
+	"week":  "week",
+	"month": "month",
+}
+
+// TimelineBucket counts the users created in the bucket starting at Start
+type TimelineBucket struct {
+	Start time.Time `json:"start" example:"2024-06-03T00:00:00Z"`
+	Count int64     `json:"count" example:"12"`
+}
+
+// TimelineResponse lists every bucket between from and to, including empty ones
+type TimelineResponse struct {
+	Interval string           `json:"interval" example:"week"`
+	Buckets  []TimelineBucket `json:"buckets"`
+}
+
+// truncateTime mirrors Postgres date_trunc in UTC; weeks start on Monday.
+func truncateTime(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	y, m, d := t.Date()
+	switch interval {
+	case "week":
+		weekday := (int(t.Weekday()) + 6) % 7 // days since Monday
+		return time.Date(y, m, d-weekday, 0, 0, 0, 0, time.UTC)
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// nextBucket returns the start of the bucket after start.
+func nextBucket(start time.Time, interval string) time.Time {
+	switch interval {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// @Summary User signup timeline
+// @Description Count users created per day, week (starting Monday) or month between from and to, in UTC. Buckets without signups are included with a count of 0. from defaults to 30 intervals before to, and to defaults to now. Accepts the same filter params as GET /users. Rejected with 400 when Postgres estimates more than MAX_SCAN_ROWS users match.
+// @Tags users
+// @Produce json
+// @Param interval query string false "Bucket size" Enums(day, week, month) default(day)
+// @Param from query string false "Start date (YYYY-MM-DD or RFC 3339)"
+// @Param to query string false "End date (YYYY-MM-DD or RFC 3339), inclusive"
+// @Success 200 {object} TimelineResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/timeline [get]
+func getUserTimeline(c echo.Context) error {
+	interval := c.QueryParam("interval")
+	if interval == "" {
+		interval = "day"
+	}
+	field, ok := timelineIntervals[interval]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "interval must be one of: day, week, month")
+	}
+
+	to := time.Now()
+	if raw := c.QueryParam("to"); raw != "" {
+		t, err := parseTime("to", raw)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		to = t
+	}
+	last := truncateTime(to, interval)
+	first := last
+	for n := 1; n < defaultTimelineBuckets; n++ {
+		// Step back into the previous bucket and truncate to its start
+		first = truncateTime(first.Add(-time.Nanosecond), interval)
+	}
+	if raw := c.QueryParam("from"); raw != "" {
+		t, err := parseTime("from", raw)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		first = truncateTime(t, interval)
+	}
+	if first.After(last) {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must not be after to")
+	}
+
+	buckets := []TimelineBucket{}
+	index := map[int64]int{}
+	for start := first; !start.After(last); start = nextBucket(start, interval) {
+		if len(buckets) == maxTimelineBuckets {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("The range spans more than %d buckets; use a larger interval", maxTimelineBuckets))
+		}
+		index[start.Unix()] = len(buckets)
+		buckets = append(buckets, TimelineBucket{Start: start})
+	}
+
+	q, err := applyScopes(c, dbFor(c))
+	if err != nil {
+		return err
+	}
+	q = q.Where("created_at >= ? AND created_at < ?", first, nextBucket(last, interval))
+	if err := guardScan(q); err != nil {
+		return err
+	}
+	expr := "date_trunc('" + field + "', created_at AT TIME ZONE 'UTC')"
+	var rows []struct {
+		Bucket time.Time
+		Count  int64
+	}
+	err = q.Select(expr + " AS bucket, COUNT(*) AS count").
+		Group(expr).
+		Scan(&rows).Error
+	if err != nil {
+		return dbError(err)
+	}
+	for _, r := range rows {
+		if i, ok := index[truncateTime(r.Bucket, interval).Unix()]; ok {
+			buckets[i].Count = r.Count
+		}
+	}
+	return c.JSON(http.StatusOK, TimelineResponse{Interval: interval, Buckets: buckets})
+}
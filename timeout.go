@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// queryTimeout is a GORM plugin giving every statement its own deadline,
+// derived from the statement's context (normally the request's), so a slow
+// query can be cancelled well before the request itself times out.
+type queryTimeout struct {
+	timeout time.Duration
+}
+
+// Name implements gorm.Plugin.
+func (q queryTimeout) Name() string { return "query_timeout" }
+
+// Initialize implements gorm.Plugin. Streamed row queries are left alone
+// since their rows are read after the callback chain has finished.
+func (q queryTimeout) Initialize(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		ctx, cancel := context.WithTimeout(tx.Statement.Context, q.timeout)
+		tx.Statement.Context = ctx
+		tx.InstanceSet("timeout:cancel", cancel)
+	}
+	after := func(tx *gorm.DB) {
+		if cancel, ok := tx.InstanceGet("timeout:cancel"); ok {
+			cancel.(context.CancelFunc)()
+		}
+	}
+	return registerAround(db, "timeout", before, after, false)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes a single failed validation rule
+type FieldError struct {
+	Field   string `json:"field" example:"email"`
+	Rule    string `json:"rule" example:"email"`
+	Message string `json:"message" example:"email must be a valid email address"`
+
+	// args fills the placeholders of the rule's catalog message
+	args map[string]string
+}
+
+// ValidationErrors is returned by structValidator when any rule fails
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, fe := range v {
+		msgs[i] = fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// structValidator implements echo.Validator using `validate` struct tags.
+// Supported rules: required, email, max=N.
+type structValidator struct{}
+
+func (structValidator) Validate(i interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(i))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+
+	var errs ValidationErrors
+	for n := 0; n < t.NumField(); n++ {
+		f := t.Field(n)
+		tag := f.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name := jsonFieldName(f)
+		value := strings.TrimSpace(fmt.Sprint(v.Field(n).Interface()))
+
+		for _, rule := range strings.Split(tag, ",") {
+			if fe, ok := checkRule(name, rule, value); !ok {
+				errs = append(errs, fe)
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func checkRule(field, rule, value string) (FieldError, bool) {
+	name, param, _ := strings.Cut(rule, "=")
+	fe := FieldError{Field: field, Rule: name, args: map[string]string{"field": field, "max": param}}
+
+	switch name {
+	case "required":
+		fe.Message = field + " is required"
+		return fe, value != ""
+	case "email":
+		fe.Message = field + " must be a valid email address"
+		return fe, value == "" || isValidEmail(value)
+	case "max":
+		max, _ := strconv.Atoi(param)
+		fe.Message = fmt.Sprintf("%s must be at most %d characters", field, max)
+		return fe, utf8.RuneCountInString(value) <= max
+	}
+	return fe, true
+}
+
+func isValidEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ready is set once startup work such as the pool warmup has finished;
+// /healthz reports 503 until then.
+var ready atomic.Bool
+
+// warmupPool opens and pings n connections at once, then returns them to the
+// idle pool, so the first requests do not pay for connection setup.
+func warmupPool(ctx context.Context, sqlDB *sql.DB, n int) error {
+	start := time.Now()
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		conn, err := sqlDB.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		if err := conn.PingContext(ctx); err != nil {
+			return err
+		}
+	}
+	log.Printf("Warmed up %d database connections in %s", n, time.Since(start))
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Webhook event statuses
+const (
+	webhookPending = "pending"
+	webhookSent    = "sent"
+	webhookDead    = "dead"
+)
+
+// webhookEventTypes lists the events that can be subscribed to with
+// WEBHOOK_EVENTS
+var webhookEventTypes = []string{"user.created", "user.updated", "user.deleted"}
+
+// WebhookEvent is an outbox row written in the same transaction as the
+// change it describes, and delivered later by the dispatcher.
+type WebhookEvent struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt     time.Time `json:"created_at"`
+	Type          string    `json:"type" gorm:"index"`
+	Payload       string    `json:"payload" gorm:"type:text"`
+	Status        string    `json:"status" gorm:"index;default:pending"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at" gorm:"index"`
+	LastError     string    `json:"last_error"`
+}
+
+// webhookBody is what receivers get POSTed
+type webhookBody struct {
+	ID        uint            `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// userChange is the data of a user.updated event
+type userChange struct {
+	Before User `json:"before"`
+	After  User `json:"after"`
+}
+
+// enqueueWebhook records an event in the outbox using tx, so it is only
+// delivered if the surrounding transaction commits. It is a no-op when no
+// WEBHOOK_URL is configured or WEBHOOK_EVENTS leaves out eventType.
+func enqueueWebhook(tx *gorm.DB, eventType string, data interface{}) error {
+	if cfg.WebhookURL == "" || !cfg.WebhookEvents[eventType] {
+		return nil
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&WebhookEvent{
+		Type:          eventType,
+		Payload:       string(payload),
+		Status:        webhookPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// runWebhookDispatcher delivers pending outbox events every poll interval
+// until ctx is cancelled.
+func runWebhookDispatcher(ctx context.Context) {
+	if cfg.WebhookURL == "" {
+		return
+	}
+	client := &http.Client{Timeout: cfg.WebhookTimeout}
+	ticker := time.NewTicker(cfg.WebhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := dispatchWebhooks(ctx, client); err != nil {
+				log.Printf("Webhook dispatch failed: %v", err)
+			}
+		}
+	}
+}
+
+// webhookBatchSize is how many due events one dispatch claims
+const webhookBatchSize = 50
+
+// dispatchWebhooks delivers one batch of due events. Deliveries run outside
+// any transaction, so no connection or row lock is held while receivers
+// take up to WEBHOOK_TIMEOUT each to answer; every result is recorded with
+// its own update.
+func dispatchWebhooks(ctx context.Context, client *http.Client) error {
+	events, err := claimWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range events {
+		ev := &events[i]
+		updates := map[string]interface{}{}
+		if err := deliverWebhook(ctx, client, ev); err != nil {
+			updates["last_error"] = err.Error()
+			if ev.Attempts >= cfg.WebhookMaxAttempts {
+				updates["status"] = webhookDead
+				log.Printf("Webhook event %d moved to dead letter after %d attempts: %v", ev.ID, ev.Attempts, err)
+			} else {
+				updates["next_attempt_at"] = time.Now().Add(webhookBackoff(ev.Attempts))
+			}
+		} else {
+			updates["status"] = webhookSent
+			updates["last_error"] = ""
+		}
+		if err := db.WithContext(ctx).Model(ev).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimWebhooks takes up to webhookBatchSize due events in a short
+// transaction, counting an attempt on each and pushing next_attempt_at past
+// the time delivering the whole batch may take. Rows are locked with SKIP
+// LOCKED so several instances can claim concurrently, and the events of a
+// dispatcher that dies mid-batch become due again once that lease runs out.
+func claimWebhooks(ctx context.Context) ([]WebhookEvent, error) {
+	var events []WebhookEvent
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", webhookPending, time.Now()).
+			Order("id").
+			Limit(webhookBatchSize).
+			Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(events))
+		for i := range events {
+			events[i].Attempts++
+			ids[i] = events[i].ID
+		}
+		lease := time.Now().Add(time.Duration(len(events)+1) * cfg.WebhookTimeout)
+		return tx.Model(&WebhookEvent{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": lease,
+		}).Error
+	})
+	return events, err
+}
+
+func deliverWebhook(ctx context.Context, client *http.Client, ev *WebhookEvent) error {
+	body, err := json.Marshal(webhookBody{
+		ID:        ev.ID,
+		Type:      ev.Type,
+		CreatedAt: ev.CreatedAt,
+		Data:      json.RawMessage(ev.Payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", ev.Type)
+	req.Header.Set("X-Webhook-ID", strconv.FormatUint(uint64(ev.ID), 10))
+	if len(cfg.WebhookSecret) > 0 {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of body keyed with WEBHOOK_SECRET,
+// so receivers can verify a delivery came from us.
+func signWebhook(body []byte) string {
+	mac := hmac.New(sha256.New, cfg.WebhookSecret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff doubles the retry delay after each attempt, up to an hour.
+func webhookBackoff(attempts int) time.Duration {
+	if attempts > 12 {
+		return time.Hour
+	}
+	return min(time.Second<<attempts, time.Hour)
+}